@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-rod/rod"
+)
+
+// Problems with the Google account which stop downloads working
+const (
+	accountOK          = ""
+	accountSuspended   = "suspended"    // the account is disabled or suspended
+	accountStorageFull = "storage full" // the account is out of storage
+)
+
+// Read the account problem shown on the page, if any.
+//
+// Disabled accounts are sent to an accounts.google.com page and
+// storage problems show a banner or dialog. Only the text of those is
+// read, so a caption or album title can't look like a problem. Only
+// English is recognised.
+const accountStateJS = `() => {
+	if (location.hostname === "accounts.google.com" && /\/(disabled|suspended)/.test(location.pathname)) {
+		return "suspended";
+	}
+	const text = Array.from(document.querySelectorAll('[role="alert"], [role="alertdialog"], [role="dialog"]'), e => e.innerText).join("\n");
+	if (/account (has been|is) (disabled|suspended)/i.test(text)) {
+		return "suspended";
+	}
+	if (/(storage (is )?full|out of (storage|space)|storage limit reached)/i.test(text)) {
+		return "storage full";
+	}
+	return "";
+}`
+
+// checkAccount returns an error if page shows the Google account is
+// suspended, and notes if it is out of storage.
+func (g *Gphotos) checkAccount(page *rod.Page, slog *slog.Logger) error {
+	res, err := page.Eval(accountStateJS)
+	if err != nil {
+		return fmt.Errorf("failed to check account state: %w", err)
+	}
+	state := res.Value.Str()
+	switch state {
+	case accountSuspended:
+		slog.Error("Google account is suspended or disabled")
+		return withCode(codeAccountSuspended, errors.New("the Google account is suspended or disabled - check it in a browser"))
+	case accountStorageFull:
+		if !g.storageFull.Swap(true) {
+			slog.Warn("Google account storage is full - downloads may fail")
+		}
+	default:
+		g.storageFull.Store(false)
+	}
+	return nil
+}
+
+// accountError explains err if the account is out of storage as that
+// is the likely cause of the failure.
+func (g *Gphotos) accountError(err error) error {
+	if !g.storageFull.Load() || errorStatus(err) < http.StatusInternalServerError {
+		return err
+	}
+	return withCode(codeStorageFull, fmt.Errorf("the Google account storage is full which may stop downloads: %w", err))
+}
+
+// accountHealth returns the account problem for the health check
+func (g *Gphotos) accountHealth() string {
+	if g.storageFull.Load() {
+		return accountStorageFull
+	}
+	return accountOK
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+)
+
+func TestCheckAccount(t *testing.T) {
+	google := newFakeGoogle(t)
+	g := startTestBrowser(t, google)
+	page, err := g.newTab(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.closeTab(page)
+
+	for _, test := range []struct {
+		html        string
+		code        string
+		storageFull bool
+	}{
+		{`<p>Library</p>`, "", false},
+		{`<div role="alert">Your account storage is full</div>`, "", true},
+		{`<div role="dialog">Your account has been disabled</div>`, codeAccountSuspended, false},
+		// Captions and titles aren't Google's messages
+		{`<p>Out of space! Storage is full of holiday photos</p>`, "", false},
+		{`<h1>My account has been suspended, a story</h1>`, "", false},
+	} {
+		err := page.SetDocumentContent(test.html)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g.storageFull.Store(false)
+		code := ""
+		err = g.checkAccount(page, slog.Default())
+		if err != nil {
+			code = errorCode(err)
+		}
+		if code != test.code {
+			t.Errorf("%s: got %v, want code %q", test.html, err, test.code)
+		}
+		if got := g.storageFull.Load(); got != test.storageFull {
+			t.Errorf("%s: storage full %v, want %v", test.html, got, test.storageFull)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-rod/rod"
+)
+
+// Limits for album listing
+const (
+	defaultAlbumLimit = 100 // items per page if not specified
+	maxStaleScrolls   = 5   // give up after this many scrolls find nothing new
+	maxAlbumListings  = 100 // album listings kept for paging
+	albumCacheTTL     = 10 * time.Minute
+)
+
+// albumIDFormat describes the album IDs accepted
+const albumIDFormat = "1 to 256 characters from A-Z, a-z, 0-9, - and _"
+
+// albumIDRe matches a valid album ID, or a photo ID in an album
+var albumIDRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// Read the hrefs and labels of the photo links currently in the grid
+const photoLinksJS = `() => Array.from(document.querySelectorAll('a[href*="/photo/"]'), a => ({
+	href: a.getAttribute("href"),
+	label: a.getAttribute("aria-label") || "",
+}))`
+
+// photoLink is a link to a photo in the grid
+type photoLink struct {
+	Href  string `json:"href"`
+	Label string `json:"label"` // eg "Photo - Landscape - Jan 5, 2024, 3:45:12 PM"
+}
+
+// Scroll the grid down a screen to lazy load more items
+const scrollGridJS = `() => {
+	const links = document.querySelectorAll('a[href*="/photo/"]');
+	if (links.length > 0) {
+		links[links.length - 1].scrollIntoView({block: "start"});
+	}
+	window.scrollBy(0, window.innerHeight);
+}`
+
+// Match the photo ID in a photo link
+var photoLinkRe = regexp.MustCompile(`/photo/([A-Za-z0-9_-]+)`)
+
+// scrollPhotoIDs scrolls the grid on page calling fn with each
+// photo ID in the order they are discovered. It stops when fn
+// returns false, the end of the grid is reached or -max-album-items
+// have been found.
+func scrollPhotoIDs(page *rod.Page, slog *slog.Logger, fn func(photoID string) bool) error {
+	return scrollPhotoLinks(page, slog, *maxAlbumItems, func(photoID, _ string) bool {
+		return fn(photoID)
+	})
+}
+
+// scrollPhotoLinks scrolls the grid on page calling fn with each
+// photo ID and the label of its link in the order they are
+// discovered. It stops when fn returns false, the end of the grid is
+// reached or maxItems have been found if it is above 0.
+func scrollPhotoLinks(page *rod.Page, slog *slog.Logger, maxItems int, fn func(photoID, label string) bool) error {
+	seen := map[string]bool{}
+	stale := 0
+	for stale < maxStaleScrolls {
+		res, err := page.Eval(photoLinksJS)
+		if err != nil {
+			return fmt.Errorf("failed to read photo links: %w", err)
+		}
+		var links []photoLink
+		err = res.Value.Unmarshal(&links)
+		if err != nil {
+			return fmt.Errorf("failed to decode photo links: %w", err)
+		}
+		found := 0
+		for _, link := range links {
+			match := photoLinkRe.FindStringSubmatch(link.Href)
+			if match == nil || seen[match[1]] {
+				continue
+			}
+			seen[match[1]] = true
+			found++
+			if !fn(match[1], link.Label) || (maxItems > 0 && len(seen) >= maxItems) {
+				return nil
+			}
+		}
+		if found == 0 {
+			stale++
+		} else {
+			stale = 0
+		}
+		slog.Debug("Scrolled grid", "found", found, "total", len(seen))
+
+		_, err = page.Eval(scrollGridJS)
+		if err != nil {
+			return fmt.Errorf("failed to scroll grid: %w", err)
+		}
+		err = page.WaitStable(time.Second)
+		if err != nil {
+			return fmt.Errorf("grid load: %w", err)
+		}
+	}
+	return nil
+}
+
+// albumListing is the photo IDs found in an album so far, in order
+type albumListing struct {
+	ids       []string
+	index     map[string]int // photo ID to its position in ids
+	complete  bool           // set if ids is the whole album
+	truncated bool           // set if -max-album-items stopped the listing
+	listed    time.Time      // when the album was scrolled
+}
+
+// add photoID to the end of the listing
+func (l *albumListing) add(photoID string) {
+	l.index[photoID] = len(l.ids)
+	l.ids = append(l.ids, photoID)
+}
+
+// albumCache keeps the album listings so paging through an album
+// carries on from the IDs already found rather than scrolling it from
+// the start for every page.
+type albumCache struct {
+	mu       sync.Mutex
+	listings map[string]*albumListing // album ID to listing
+}
+
+// get returns the listing for albumID or nil if there isn't a fresh one
+func (c *albumCache) get(albumID string) *albumListing {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l := c.listings[albumID]
+	if l == nil || time.Since(l.listed) > albumCacheTTL {
+		return nil
+	}
+	return l
+}
+
+// set the listing for albumID
+func (c *albumCache) set(albumID string, l *albumListing) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.listings == nil || len(c.listings) >= maxAlbumListings {
+		c.listings = map[string]*albumListing{}
+	}
+	c.listings[albumID] = l
+}
+
+// albumCursor returns the opaque cursor for the page after photoID
+func albumCursor(photoID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(photoID))
+}
+
+// parseAlbumCursor returns the photo ID in cursor
+func parseAlbumCursor(cursor string) (string, bool) {
+	photoID, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !albumIDRe.Match(photoID) {
+		return "", false
+	}
+	return string(photoID), true
+}
+
+// idStream writes a JSON page of IDs as they are found
+type idStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	started bool
+	n       int // IDs written
+}
+
+// start writes the headers and the start of the page if not done
+// already
+func (s *idStream) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	s.w.Header().Set("Content-Type", "application/json")
+	s.flusher, _ = s.w.(http.Flusher)
+	_, _ = fmt.Fprint(s.w, `{"ids":[`)
+}
+
+// write photoID to the page
+func (s *idStream) write(photoID string) {
+	s.start()
+	if s.n > 0 {
+		_, _ = fmt.Fprint(s.w, ",")
+	}
+	id, _ := json.Marshal(photoID)
+	_, _ = s.w.Write(id)
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+	s.n++
+}
+
+// end the page with the cursor for the next, or "" for none.
+//
+// If there is no next page but the listing was cut short, by err or
+// by the limit on the items listed, truncated is set so the page isn't
+// taken for the last. err is added as the error.
+func (s *idStream) end(next string, truncated bool, err error) {
+	s.start()
+	nextJSON, _ := json.Marshal(next)
+	_, _ = fmt.Fprintf(s.w, `],"next":%s`, nextJSON)
+	if next == "" && (truncated || err != nil) {
+		_, _ = fmt.Fprint(s.w, `,"truncated":true`)
+	}
+	if err != nil {
+		errJSON, _ := json.Marshal(err.Error())
+		_, _ = fmt.Fprintf(s.w, `,"error":%s`, errJSON)
+	}
+	_, _ = fmt.Fprint(s.w, "}")
+}
+
+// Serve the photo IDs in an album.
+//
+// The IDs are streamed as JSON as they are discovered, a page at a
+// time. Pass the returned "next" value as the cursor parameter to
+// fetch the next page. A last page which doesn't reach the end of the
+// album, because of an error or -max-album-items, has "truncated" set.
+//
+// The cursor is the last photo ID on the page. The IDs found are kept
+// for a while so the next pages come from them. When more are needed
+// the album is scrolled to at least twice as far as the cursor, so
+// paging through it takes a logarithmic number of scrolls through the
+// album rather than one for each page.
+func (g *Gphotos) getAlbum(w http.ResponseWriter, r *http.Request) {
+	albumID := r.PathValue("albumID")
+	slog := slog.With("album", albumID)
+	slog.Info("got album request")
+	if !albumIDRe.MatchString(albumID) {
+		writeErrorCode(w, codeBadRequest, fmt.Sprintf("malformed album ID %q - expecting %s", albumID, albumIDFormat))
+		return
+	}
+
+	q := r.URL.Query()
+	cursorID, limit := "", defaultAlbumLimit
+	var err error
+	if cursor := q.Get("cursor"); cursor != "" {
+		var ok bool
+		cursorID, ok = parseAlbumCursor(cursor)
+		if !ok {
+			writeErrorCode(w, codeBadRequest, "invalid cursor")
+			return
+		}
+	}
+	if s := q.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			writeErrorCode(w, codeBadRequest, "invalid limit")
+			return
+		}
+	}
+
+	// Serve the page from the IDs already found if possible. start
+	// is the position of the page or -1 if the cursor isn't known.
+	start := 0
+	cached := g.albums.get(albumID)
+	if cursorID != "" {
+		start = -1
+		if cached != nil {
+			if i, ok := cached.index[cursorID]; ok {
+				start = i + 1
+			}
+		}
+	}
+	if cached != nil && start >= 0 && (cached.complete || len(cached.ids) > start+limit) {
+		s := &idStream{w: w}
+		end := min(start+limit, len(cached.ids))
+		for _, photoID := range cached.ids[start:end] {
+			s.write(photoID)
+		}
+		next := ""
+		if len(cached.ids) > end {
+			next = albumCursor(cached.ids[end-1])
+		}
+		s.end(next, cached.truncated, nil)
+		slog.Info("Listed album from cache", "start", start, "items", s.n, "next", next != "")
+		return
+	}
+
+	// Otherwise scroll the album in the least busy browser
+	b := g.metaBrowser()
+	unlock, err := b.lockMeta()
+	if err != nil {
+		slog.Error("Failed to start browser", "err", err)
+		writeError(w, err)
+		return
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(r.Context(), *downloadTimeout)
+	defer cancel()
+
+	page, err := b.newTab(ctx)
+	if err != nil {
+		slog.Error("Failed to open browser tab", "err", err)
+		writeError(w, err)
+		return
+	}
+	defer b.closeTab(page)
+
+	slog.Debug("Navigate to album URL")
+	err = navigate(page, slog, *albumURL+albumID)
+	if err == nil {
+		err = waitPage(page)
+	}
+	if err != nil {
+		slog.Error("Failed to load album", "err", err)
+		writeError(w, err)
+		return
+	}
+
+	// Stream the IDs of the page as we find them, then read ahead
+	// to fill the cache for the pages after
+	s := &idStream{w: w}
+	listing := &albumListing{index: map[string]int{}, listed: time.Now()}
+	target := func() int {
+		return max(start+limit+1, 2*start)
+	}
+	stopped := false
+	err = scrollPhotoIDs(page, slog, func(photoID string) bool {
+		i := len(listing.ids)
+		listing.add(photoID)
+		if start < 0 {
+			if photoID == cursorID {
+				start = i + 1
+			}
+			return true
+		}
+		if i >= start && i < start+limit {
+			s.write(photoID)
+		}
+		// Read one past the page to see if there is another page
+		stopped = len(listing.ids) >= target()
+		return !stopped
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		slog.Error("Failed to list album", "err", err)
+		if !s.started {
+			writeError(w, err)
+			return
+		}
+		// Headers are already sent so the error goes at the end
+	}
+	listing.complete = err == nil && !stopped
+	listing.truncated = listing.complete && *maxAlbumItems > 0 && len(listing.ids) >= *maxAlbumItems
+	g.albums.set(albumID, listing)
+	if start < 0 {
+		if !s.started {
+			writeErrorCode(w, codeBadRequest, "cursor not found in the album - it may have changed so list it again from the start")
+		}
+		return
+	}
+	next := ""
+	if end := start + limit; len(listing.ids) > end {
+		next = albumCursor(listing.ids[end-1])
+	}
+	if errors.Is(err, context.Canceled) {
+		err = nil
+	}
+	s.end(next, listing.truncated, err)
+	slog.Info("Listed album", "start", start, "items", s.n, "found", len(listing.ids), "next", next != "", "truncated", listing.truncated)
+}
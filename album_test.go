@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// albumPage is the JSON for a page of GET /album/{albumID}
+type albumPage struct {
+	IDs       []string `json:"ids"`
+	Next      string   `json:"next"`
+	Truncated bool     `json:"truncated"`
+	Error     string   `json:"error"`
+}
+
+// getAlbumPage fetches a page of albumID from srv
+func getAlbumPage(t *testing.T, srv, albumID, cursor string, limit int) (int, albumPage) {
+	t.Helper()
+	q := url.Values{"limit": {fmt.Sprint(limit)}}
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+	resp, body := getPhoto(t, srv, "/album/"+albumID+"?"+q.Encode())
+	var page albumPage
+	if resp.StatusCode == http.StatusOK {
+		err := json.Unmarshal(body, &page)
+		if err != nil {
+			t.Fatalf("bad album page %q: %v", body, err)
+		}
+	}
+	return resp.StatusCode, page
+}
+
+// listAlbum pages through albumID limit at a time returning the IDs,
+// the number of pages and whether the last page was truncated
+func listAlbum(t *testing.T, srv, albumID string, limit int) ([]string, int, bool) {
+	t.Helper()
+	var ids []string
+	cursor := ""
+	for pages := 1; ; pages++ {
+		status, page := getAlbumPage(t, srv, albumID, cursor, limit)
+		if status != http.StatusOK {
+			t.Fatalf("page %d: status %d", pages, status)
+		}
+		if len(page.IDs) > limit {
+			t.Fatalf("page %d has %d IDs, more than the limit %d", pages, len(page.IDs), limit)
+		}
+		if page.Error != "" {
+			t.Errorf("page %d: error %q", pages, page.Error)
+		}
+		ids = append(ids, page.IDs...)
+		if page.Next == "" {
+			return ids, pages, page.Truncated
+		}
+		if page.Truncated {
+			t.Errorf("page %d is truncated but has a next page", pages)
+		}
+		cursor = page.Next
+	}
+}
+
+// testAlbum returns n photo IDs for an album
+func testAlbum(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("AF1QipAlbumPhoto%09d", i)
+	}
+	return ids
+}
+
+func TestAlbumBadRequests(t *testing.T) {
+	g := newTestGphotos(t, newFakeDownloader(t))
+	srv := newTestServer(t, g)
+	for _, path := range []string{
+		"/album/bad.album",
+		"/album/album1?cursor=!!!",
+		"/album/album1?cursor=" + albumCursor("a/b"),
+		"/album/album1?limit=0",
+		"/album/album1?limit=x",
+	} {
+		resp, body := getPhoto(t, srv.URL, path)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400: %s", path, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestAlbumCursor(t *testing.T) {
+	photoID, ok := parseAlbumCursor(albumCursor(testPhotoID))
+	if !ok || photoID != testPhotoID {
+		t.Errorf("got %q %v, want %q", photoID, ok, testPhotoID)
+	}
+}
+
+func TestAlbumFromCache(t *testing.T) {
+	// There is no browser so this fails if the album is scrolled
+	g := newTestGphotos(t, newFakeDownloader(t))
+	srv := newTestServer(t, g)
+	want := testAlbum(25)
+	listing := &albumListing{index: map[string]int{}, complete: true, listed: time.Now()}
+	for _, photoID := range want {
+		listing.add(photoID)
+	}
+	g.albums.set("album1", listing)
+
+	got, pages, truncated := listAlbum(t, srv.URL, "album1", 10)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if pages != 3 || truncated {
+		t.Errorf("%d pages truncated %v, want 3 not truncated", pages, truncated)
+	}
+
+	// An exact number of pages has no empty page at the end
+	_, pages, _ = listAlbum(t, srv.URL, "album1", 5)
+	if pages != 5 {
+		t.Errorf("%d pages, want 5", pages)
+	}
+
+	// A listing stopped by -max-album-items says so on the last page
+	listing.truncated = true
+	_, _, truncated = listAlbum(t, srv.URL, "album1", 10)
+	if !truncated {
+		t.Error("listing cut short by -max-album-items not truncated")
+	}
+}
+
+func TestIDStreamEnd(t *testing.T) {
+	for _, test := range []struct {
+		next      string
+		truncated bool
+		err       error
+		want      string
+	}{
+		{"", false, nil, `{"ids":["a"],"next":""}`},
+		{"b", false, nil, `{"ids":["a"],"next":"b"}`},
+		{"", true, nil, `{"ids":["a"],"next":"","truncated":true}`},
+		{"b", true, nil, `{"ids":["a"],"next":"b"}`},
+		{"", false, errors.New("grid load failed"), `{"ids":["a"],"next":"","truncated":true,"error":"grid load failed"}`},
+	} {
+		rec := httptest.NewRecorder()
+		s := &idStream{w: rec}
+		s.write("a")
+		s.end(test.next, test.truncated, test.err)
+		if got := rec.Body.String(); got != test.want {
+			t.Errorf("end(%q, %v, %v) wrote %s, want %s", test.next, test.truncated, test.err, got, test.want)
+		}
+	}
+}
+
+func TestAlbumWithBrowser(t *testing.T) {
+	google := newFakeGoogle(t)
+	want := testAlbum(25)
+	google.addAlbum("album1", want)
+	g := startTestBrowser(t, google)
+	srv := newTestServer(t, g)
+
+	got, pages, truncated := listAlbum(t, srv.URL, "album1", 10)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if pages != 3 || truncated {
+		t.Errorf("%d pages truncated %v, want 3 not truncated", pages, truncated)
+	}
+	// It is all cached now
+	if l := g.albums.get("album1"); l == nil || !l.complete || len(l.ids) != len(want) {
+		t.Errorf("album not cached completely: %+v", l)
+	}
+
+	// A cursor which isn't in the album
+	status, _ := getAlbumPage(t, srv.URL, "unknown", albumCursor(testPhotoID), 10)
+	if status == http.StatusOK {
+		t.Errorf("status %d for an unknown album", status)
+	}
+}
+
+func TestAlbumMaxItemsWithBrowser(t *testing.T) {
+	google := newFakeGoogle(t)
+	want := testAlbum(25)
+	google.addAlbum("album1", want)
+	g := startTestBrowser(t, google)
+	setFlag(t, "max-album-items", "15")
+	srv := newTestServer(t, g)
+
+	got, _, truncated := listAlbum(t, srv.URL, "album1", 10)
+	if !reflect.DeepEqual(got, want[:15]) {
+		t.Errorf("got %q, want %q", got, want[:15])
+	}
+	if !truncated {
+		t.Error("listing cut short by -max-album-items not truncated")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// allowlist is the set of photo IDs which may be requested, loaded
+// from -allowlist-file
+type allowlist struct {
+	path string
+	mu   sync.RWMutex
+	ids  map[string]bool
+}
+
+// newAllowlist loads the allowlist from path.
+//
+// It returns nil if path is empty meaning all IDs are allowed.
+func newAllowlist(path string) (*allowlist, error) {
+	if path == "" {
+		return nil, nil
+	}
+	a := &allowlist{path: path}
+	err := a.load()
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// load (or reload) the allowlist from the file.
+//
+// The file has one photo ID per line. Blank lines and lines starting
+// with # are ignored.
+func (a *allowlist) load() error {
+	f, err := os.Open(a.path)
+	if err != nil {
+		return fmt.Errorf("failed to open allowlist: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	ids := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ids[line] = true
+	}
+	err = scanner.Err()
+	if err != nil {
+		return fmt.Errorf("failed to read allowlist: %w", err)
+	}
+	a.mu.Lock()
+	a.ids = ids
+	a.mu.Unlock()
+	slog.Info("Loaded allowlist", "path", a.path, "ids", len(ids))
+	return nil
+}
+
+// allowed returns true if photoID may be requested.
+//
+// A nil allowlist allows everything.
+func (a *allowlist) allowed(photoID string) bool {
+	if a == nil {
+		return true
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.ids[photoID]
+}
+
+// checkAllowed writes a 403 error and returns false if photoID isn't
+// in the allowlist.
+func (g *Gphotos) checkAllowed(w http.ResponseWriter, photoID string) bool {
+	if g.allow.allowed(photoID) {
+		return true
+	}
+	slog.Warn("Photo ID not in allowlist", "id", photoID)
+	writeErrorCode(w, codeForbidden, "photo ID not allowed")
+	return false
+}
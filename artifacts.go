@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/go-rod/rod"
+)
+
+// artifactTimeout is how long to spend capturing the page after a
+// failure - the page may be wedged
+const artifactTimeout = 10 * time.Second
+
+// saveFailureArtifacts saves a screenshot and the HTML of page with
+// the error into a new directory in the -debug-dir when -debug is set,
+// so layout changes which break downloads can be diagnosed.
+func saveFailureArtifacts(page *rod.Page, slog *slog.Logger, photoID string, failure error) {
+	if !*debug || artifactDir == "" {
+		return
+	}
+	dir := filepath.Join(artifactDir, time.Now().Format("20060102T150405.000")+"-"+photoID)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		slog.Error("Failed to make debug artifact directory", "err", err)
+		return
+	}
+	// The page's context may have timed out with the download
+	ctx, cancel := context.WithTimeout(context.Background(), artifactTimeout)
+	defer cancel()
+	page = page.Context(ctx)
+	url := ""
+	if info, err := page.Info(); err == nil {
+		url = info.URL
+	}
+	report := fmt.Sprintf("photo: %s\nurl: %s\ntime: %s\nerror: %v\n", photoID, url, time.Now().Format(time.RFC3339), failure)
+	err = os.WriteFile(filepath.Join(dir, "error.txt"), []byte(report), 0600)
+	if err != nil {
+		slog.Error("Failed to save debug artifact", "err", err)
+	}
+	png, err := page.Screenshot(false, nil)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "screenshot.png"), png, 0600)
+	}
+	if err != nil {
+		slog.Error("Failed to save debug screenshot", "err", err)
+	}
+	html, err := page.HTML()
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "page.html"), []byte(html), 0600)
+	}
+	if err != nil {
+		slog.Error("Failed to save debug page HTML", "err", err)
+	}
+	slog.Info("Saved debug artifacts for failed download", "dir", dir)
+	pruneArtifacts()
+}
+
+// pruneArtifacts removes all but the newest -debug-keep artifact
+// directories
+func pruneArtifacts() {
+	if *debugKeep <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(artifactDir)
+	if err != nil {
+		slog.Error("Failed to read debug artifact directory", "err", err)
+		return
+	}
+	var dirs []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, entry.Name())
+		}
+	}
+	// The names start with the time so sort oldest first
+	slices.Sort(dirs)
+	for len(dirs) > *debugKeep {
+		err := os.RemoveAll(filepath.Join(artifactDir, dirs[0]))
+		if err != nil {
+			slog.Error("Failed to remove old debug artifacts", "dir", dirs[0], "err", err)
+		}
+		dirs = dirs[1:]
+	}
+}
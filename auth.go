@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// addrTokens maps each -addr to the token from -addr-token needed to
+// use it
+var addrTokens map[string]string
+
+// parseAddrTokens parses the ADDR=TOKEN values of -addr-token checking
+// each ADDR is one of addrs.
+func parseAddrTokens(values, addrs []string) (map[string]string, error) {
+	tokens := map[string]string{}
+	for _, value := range values {
+		addr, token, ok := strings.Cut(value, "=")
+		if !ok || token == "" {
+			return nil, fmt.Errorf("%q isn't ADDR=TOKEN", value)
+		}
+		if !slices.Contains(addrs, addr) {
+			return nil, fmt.Errorf("%q isn't one of the -addr addresses %q", addr, addrs)
+		}
+		if _, found := tokens[addr]; found {
+			return nil, fmt.Errorf("more than one token for %q", addr)
+		}
+		tokens[addr] = token
+	}
+	return tokens, nil
+}
+
+// hasBearer returns true if r carries token as a bearer token
+func hasBearer(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// requireToken wraps next so requests need token, the -addr-token of
+// the address they came in on, as a bearer token.
+//
+// The -admin-token is accepted too so admin actions work on any
+// address.
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hasBearer(r, token) && (*adminToken == "" || !hasBearer(r, *adminToken)) {
+			slog.Warn("Rejected request without the address token", "path", r.URL.Path, "client", clientIP(r))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+program+`"`)
+			writeErrorCode(w, codeUnauthorized, "token required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkAdmin returns true if r carries the -admin-token as a bearer
+// token, writing an error and returning false otherwise.
+//
+// Admin actions are disabled if -admin-token isn't set.
+func checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if *adminToken == "" {
+		writeErrorCode(w, codeForbidden, "admin actions are disabled - set -admin-token")
+		return false
+	}
+	if !hasBearer(r, *adminToken) {
+		slog.Warn("Rejected admin request", "path", r.URL.Path, "client", clientIP(r))
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+program+`"`)
+		writeErrorCode(w, codeUnauthorized, "admin token required")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Failure classes for the circuit breaker
+const (
+	failureAuth      = "auth"      // the browser isn't logged in or the account is unusable
+	failureTransient = "transient" // the browser or Google failed and may recover
+	failureClient    = "client"    // the request was bad or the photo doesn't exist
+	failureLocal     = "local"     // the local disk failed
+	failureBusy      = "busy"      // turned away by -max-queue without using the browser
+)
+
+// isLocalDiskError returns true if err is from the local disk rather
+// than the browser, eg the download directory being full
+func isLocalDiskError(err error) bool {
+	var linkErr *os.LinkError
+	return errors.As(err, &linkErr) ||
+		errors.Is(err, fs.ErrPermission) ||
+		errors.Is(err, syscall.ENOSPC) ||
+		errors.Is(err, syscall.EDQUOT) ||
+		errors.Is(err, syscall.EROFS)
+}
+
+// classifyFailure returns the failure class of the download error err
+func classifyFailure(err error) string {
+	if errors.Is(err, context.Canceled) {
+		return failureClient
+	}
+	switch errorCode(err) {
+	case codeNotAuthenticated, codeAccountSuspended:
+		return failureAuth
+	case codeBadRequest, codeForbidden, codePhotoNotFound, codeLockedFolder, codeTooLarge:
+		return failureClient
+	case codeQueueFull:
+		return failureBusy
+	case codeStorageFull:
+		// Google is working but the account is full, which
+		// tripping the breaker won't fix
+		return failureClient
+	}
+	if isLocalDiskError(err) {
+		return failureLocal
+	}
+	return failureTransient
+}
+
+// Circuit breaker states
+const (
+	breakerClosed   = "closed"    // downloads pass through
+	breakerOpen     = "open"      // downloads fail fast until the cooldown ends
+	breakerHalfOpen = "half_open" // one download is let through to probe recovery
+)
+
+// breaker is a circuit breaker in front of a downloader. After
+// -breaker-threshold downloads in a row fail with auth or transient
+// errors it opens and fails downloads straight away for
+// -breaker-cooldown, then lets one through to see if the browser has
+// recovered.
+type breaker struct {
+	next downloader
+
+	mu          sync.Mutex
+	state       string
+	failures    int       // auth or transient failures in a row
+	lastClass   string    // class of the last failure counted
+	lastError   string    // message of the last failure counted
+	openedAt    time.Time // when the breaker last opened
+	probing     bool      // set while the half open probe is in progress
+	rejected    int64     // downloads failed fast since start
+	transitions int64     // times the breaker has opened
+}
+
+// check *breaker satisfies the interface
+var _ downloader = (*breaker)(nil)
+
+// newBreaker returns a circuit breaker in front of next
+func newBreaker(next downloader) *breaker {
+	return &breaker{next: next, state: breakerClosed}
+}
+
+// allow returns nil if a download may go ahead or an error to fail it
+// with. probe is set if the download is the half open probe.
+func (b *breaker) allow() (probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerOpen && time.Since(b.openedAt) >= *breakerCooldown {
+		slog.Info("Circuit breaker half open - probing with the next download")
+		b.state = breakerHalfOpen
+	}
+	switch b.state {
+	case breakerClosed:
+		return false, nil
+	case breakerHalfOpen:
+		if !b.probing {
+			b.probing = true
+			return true, nil
+		}
+	}
+	b.rejected++
+	wait := max(*breakerCooldown-time.Since(b.openedAt), time.Second)
+	return false, withCode(codeCircuitOpen, fmt.Errorf("downloads are failing (%s: %s) - retry in %ds", b.lastClass, b.lastError, int(math.Ceil(wait.Seconds()))))
+}
+
+// done records the result of a download let through by allow
+func (b *breaker) done(probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+	}
+	class := ""
+	if err != nil {
+		class = classifyFailure(err)
+	}
+	if class == failureLocal || class == failureBusy {
+		// Says nothing about the browser so leave the state alone
+		return
+	}
+	if class == "" || class == failureClient {
+		// The browser is working even if the photo wasn't found
+		if b.state != breakerClosed {
+			slog.Info("Circuit breaker closed - downloads recovered")
+		}
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	b.lastClass = class
+	b.lastError = err.Error()
+	if probe || (b.state == breakerClosed && b.failures >= *breakerThreshold) {
+		slog.Warn("Circuit breaker open - failing downloads fast", "failures", b.failures, "class", class, "cooldown", *breakerCooldown, "err", err)
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		b.transitions++
+	}
+}
+
+// breakerJSON is the circuit breaker state in GET /stats
+type breakerJSON struct {
+	State     string `json:"state"`
+	Failures  int    `json:"failures"`             // auth or transient failures in a row
+	LastClass string `json:"last_class,omitempty"` // class of the last failure counted
+	Rejected  int64  `json:"rejected"`             // downloads failed fast
+	Opened    int64  `json:"opened"`               // times the breaker has opened
+}
+
+// snapshot returns the breaker state as JSON
+func (b *breaker) snapshot() *breakerJSON {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &breakerJSON{
+		State:     b.state,
+		Failures:  b.failures,
+		LastClass: b.lastClass,
+		Rejected:  b.rejected,
+		Opened:    b.transitions,
+	}
+}
+
+// Download photoID unless the breaker is open
+func (b *breaker) Download(photoID string) (string, error) {
+	return b.DownloadWithProgress(photoID, nil)
+}
+
+// DownloadWithProgress downloads photoID unless the breaker is open
+// calling report as the download proceeds.
+func (b *breaker) DownloadWithProgress(photoID string, report func(progress)) (string, error) {
+	return b.DownloadFrame(photoID, 0, report)
+}
+
+// DownloadFrame downloads frame of photoID unless the breaker is open
+// calling report as the download proceeds.
+func (b *breaker) DownloadFrame(photoID string, frame int, report func(progress)) (string, error) {
+	probe, err := b.allow()
+	if err != nil {
+		slog.Warn("Circuit breaker open - rejecting download", "id", photoID)
+		return "", err
+	}
+	path, err := b.next.DownloadFrame(photoID, frame, report)
+	b.done(probe, err)
+	return path, err
+}
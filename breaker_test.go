@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want string
+	}{
+		{withCode(codeNotAuthenticated, errors.New("logged out")), failureAuth},
+		{withCode(codeAccountSuspended, errors.New("suspended")), failureAuth},
+		{httpError(http.StatusNotFound), failureClient},
+		{withCode(codeStorageFull, errors.New("full")), failureClient},
+		{httpError(http.StatusPaymentRequired), failureClient},
+		{fmt.Errorf("failed to rename download: %w", &os.LinkError{Op: "rename", Old: "a", New: "b", Err: syscall.EXDEV}), failureLocal},
+		{&fs.PathError{Op: "write", Path: "x", Err: syscall.ENOSPC}, failureLocal},
+		{&fs.PathError{Op: "open", Path: "x", Err: syscall.EACCES}, failureLocal},
+		{errors.New("browser crashed"), failureTransient},
+		{fmt.Errorf("download failed, file not found: %w", &fs.PathError{Op: "stat", Path: "x", Err: syscall.ENOENT}), failureTransient},
+	} {
+		if got := classifyFailure(test.err); got != test.want {
+			t.Errorf("classifyFailure(%v) = %q, want %q", test.err, got, test.want)
+		}
+	}
+}
+
+// newTestBreaker returns a breaker opening after threshold failures in
+// front of a fakeDownloader
+func newTestBreaker(t *testing.T, threshold int) (*breaker, *fakeDownloader) {
+	setFlag(t, "breaker-threshold", fmt.Sprint(threshold))
+	setFlag(t, "breaker-cooldown", "1h")
+	d := newFakeDownloader(t)
+	return newBreaker(d), d
+}
+
+func TestBreakerIgnoresStorageFullAndLocalErrors(t *testing.T) {
+	b, d := newTestBreaker(t, 2)
+	for _, err := range []error{
+		withCode(codeStorageFull, errors.New("full")),
+		&fs.PathError{Op: "write", Path: "x", Err: syscall.ENOSPC},
+	} {
+		d.setError(testPhotoID, err)
+		for i := 0; i < 5; i++ {
+			_, _ = b.Download(testPhotoID)
+		}
+		if s := b.snapshot(); s.State != breakerClosed || s.Failures != 0 {
+			t.Errorf("%v: breaker %+v, want closed with no failures", err, s)
+		}
+	}
+	if got := d.calls.Load(); got != 10 {
+		t.Errorf("%d downloads tried, want 10", got)
+	}
+}
+
+func TestBreakerOpens(t *testing.T) {
+	b, d := newTestBreaker(t, 2)
+	d.setError(testPhotoID, errors.New("browser crashed"))
+	for i := 0; i < 2; i++ {
+		_, _ = b.Download(testPhotoID)
+	}
+	_, err := b.Download(testPhotoID)
+	if errorCode(err) != codeCircuitOpen {
+		t.Fatalf("got %v, want circuit open", err)
+	}
+	if got := d.calls.Load(); got != 2 {
+		t.Errorf("%d downloads tried, want 2", got)
+	}
+}
+
+func TestBreakerLocalErrorKeepsCount(t *testing.T) {
+	b, d := newTestBreaker(t, 2)
+	d.setError(testPhotoID, errors.New("browser crashed"))
+	_, _ = b.Download(testPhotoID)
+	d.setError(testPhotoID, &fs.PathError{Op: "write", Path: "x", Err: syscall.ENOSPC})
+	_, _ = b.Download(testPhotoID)
+	if s := b.snapshot(); s.Failures != 1 {
+		t.Errorf("%d failures after a local error, want 1", s.Failures)
+	}
+	d.setError(testPhotoID, errors.New("browser crashed"))
+	_, _ = b.Download(testPhotoID)
+	if s := b.snapshot(); s.State != breakerOpen {
+		t.Errorf("breaker %q, want open", s.State)
+	}
+}
+
+func TestBreakerOffByDefault(t *testing.T) {
+	if got := flag.Lookup("breaker-threshold").DefValue; got != "0" {
+		t.Errorf("-breaker-threshold defaults to %s, want 0", got)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/go-rod/rod/lib/launcher"
+	"github.com/go-rod/rod/lib/launcher/flags"
+	"github.com/go-rod/rod/lib/utils"
+)
+
+// setChromeFlags adds the -chrome-flag switches to l, along with the
+// ones needed to run as root or in a container and for -lite-render,
+// -locale, -ignore-cert-errors and -host-rules.
+func setChromeFlags(l *launcher.Launcher) {
+	// Chrome refuses to start as root without --no-sandbox and the
+	// sandbox usually can't be set up in a container.
+	//
+	// The launcher sets --disable-dev-shm-usage already.
+	if os.Geteuid() == 0 || utils.InContainer {
+		slog.Warn("Running as root or in a container - disabling the browser sandbox")
+		l.Set(flags.NoSandbox)
+	}
+	// Don't load images or autoplay videos - downloads still work as
+	// they don't go through the renderer
+	if *liteRender {
+		l.Set("blink-settings", "imagesEnabled=false")
+		l.Set("autoplay-policy", "user-gesture-required")
+	}
+	// Only for test servers with self-signed certificates and
+	// trusted TLS intercepting proxies
+	if *ignoreCertErrs {
+		slog.Warn("Ignoring TLS certificate errors in the browser - this is insecure")
+		l.Set("ignore-certificate-errors")
+	}
+	// The UI language, for the keyboard shortcuts and selectors
+	if *locale != "" {
+		l.Set("lang", *locale)
+		l.Set("accept-lang", acceptLanguage(*locale))
+	}
+	if *hostRules != "" {
+		l.Set("host-resolver-rules", *hostRules)
+	}
+	for _, chromeFlag := range chromeFlags {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(chromeFlag, "-"), "=")
+		if hasValue {
+			l.Set(flags.Flag(name), strings.Split(value, ",")...)
+		} else {
+			l.Set(flags.Flag(name))
+		}
+		slog.Debug("Added browser flag", "flag", name, "value", value)
+	}
+}
+
+// checkHostRules checks rules is a comma separated list of "MAP
+// pattern replacement" and "EXCLUDE pattern" rules as the browser's
+// --host-resolver-rules expects
+func checkHostRules(rules string) error {
+	for _, rule := range strings.Split(rules, ",") {
+		fields := strings.Fields(rule)
+		if len(fields) == 0 {
+			return errors.New("empty rule")
+		}
+		switch {
+		case strings.EqualFold(fields[0], "MAP") && len(fields) == 3:
+		case strings.EqualFold(fields[0], "EXCLUDE") && len(fields) == 2:
+		default:
+			return fmt.Errorf("bad rule %q - expecting MAP pattern replacement or EXCLUDE pattern", strings.TrimSpace(rule))
+		}
+	}
+	return nil
+}
+
+// hasDisplay returns true if a visible browser window can be opened
+func hasDisplay() bool {
+	switch runtime.GOOS {
+	case "windows", "darwin":
+		return true
+	}
+	return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+}
+
+// knownBrowsers maps the names accepted by -browser to the
+// executable names to search the PATH for.
+var knownBrowsers = map[string][]string{
+	"chrome":   {"google-chrome", "google-chrome-stable", "chrome", "chrome.exe"},
+	"chromium": {"chromium", "chromium-browser", "chromium.exe"},
+	"edge":     {"microsoft-edge", "microsoft-edge-stable", "msedge", "msedge.exe"},
+	"brave":    {"brave-browser", "brave", "brave.exe"},
+	"firefox":  {"firefox", "firefox.exe"},
+}
+
+// findBrowser returns the path to the browser binary selected by
+// the -browser flag, or the one found by rod if unset.
+func findBrowser() (string, error) {
+	if *browserName == "" {
+		path, ok := launcher.LookPath()
+		if ok {
+			return path, nil
+		}
+		if *autoDownload {
+			return downloadBrowser()
+		}
+		return "", errors.New("browser not found - use the -browser flag or -auto-download-browser")
+	}
+
+	var path string
+	if names, ok := knownBrowsers[strings.ToLower(*browserName)]; ok {
+		for _, name := range names {
+			p, err := exec.LookPath(name)
+			if err == nil {
+				path = p
+				break
+			}
+		}
+		if path == "" {
+			return "", fmt.Errorf("browser %q not found in PATH", *browserName)
+		}
+	} else {
+		fi, err := os.Stat(*browserName)
+		if err != nil {
+			return "", fmt.Errorf("browser %q: %w", *browserName, err)
+		}
+		if fi.IsDir() {
+			return "", fmt.Errorf("browser %q is a directory", *browserName)
+		}
+		path = *browserName
+	}
+
+	return path, nil
+}
+
+// downloadBrowser returns the path to the Chromium -browser-revision
+// kept in the config directory, downloading it first if needed.
+//
+// With -offline it returns an error rather than downloading.
+func downloadBrowser() (string, error) {
+	b := launcher.NewBrowser()
+	b.Revision = *browserRevision
+	b.RootDir = filepath.Join(configRoot, "chromium")
+	b.Logger = log.New(logger{}, "", 0)
+	err := b.Validate()
+	if err == nil {
+		slog.Debug("Using downloaded browser", "path", b.BinPath(), "revision", b.Revision)
+		return b.BinPath(), nil
+	}
+	if *offline {
+		return "", fmt.Errorf("browser revision %d not downloaded and -offline is set: %w", b.Revision, err)
+	}
+	slog.Info("Downloading browser", "revision", b.Revision, "dir", b.Dir())
+	path, err := b.Get()
+	if err != nil {
+		return "", fmt.Errorf("failed to download browser revision %d: %w", b.Revision, err)
+	}
+	// Check the download runs
+	err = b.Validate()
+	if err != nil {
+		_ = os.RemoveAll(b.Dir())
+		return "", fmt.Errorf("downloaded browser revision %d is broken: %w", b.Revision, err)
+	}
+	slog.Info("Downloaded browser", "path", path)
+	return path, nil
+}
+
+// checkBrowser asks the running browser for its version with the
+// Browser.getVersion CDP call, failing if it doesn't answer and
+// warning if it isn't Chromium based.
+//
+// Download waiting and keypresses rely on the Page.downloadWillBegin
+// and Input.dispatchKeyEvent CDP calls which only Chromium based
+// browsers implement fully.
+func (g *Gphotos) checkBrowser() error {
+	v, err := g.browser.Version()
+	if err != nil {
+		return withExit(exitBrowser, fmt.Errorf("browser %q didn't answer the DevTools Browser.getVersion call - use a Chromium based browser: %w", browserPath, err))
+	}
+	slog.Debug("Browser version", "product", v.Product, "protocol", v.ProtocolVersion)
+	if !isChromium(v.Product) {
+		slog.Warn("Browser isn't Chromium based so has limited CDP support - downloads will probably not work", "browser_path", browserPath, "product", v.Product)
+	}
+	return nil
+}
+
+// isChromium returns true if product, from Browser.getVersion, is a
+// Chromium based browser, eg "HeadlessChrome/140.0.7339.207"
+func isChromium(product string) bool {
+	return strings.Contains(product, "Chrome/") || strings.HasPrefix(product, "Edg/")
+}
+
+// isFirefox returns true if the browser at path is Firefox
+func isFirefox(path string) bool {
+	return strings.Contains(strings.ToLower(filepath.Base(path)), "firefox")
+}
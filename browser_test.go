@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestIsChromium(t *testing.T) {
+	for _, test := range []struct {
+		product string
+		want    bool
+	}{
+		{"HeadlessChrome/140.0.7339.207", true},
+		{"Chrome/126.0.6478.126", true},
+		{"Edg/126.0.2592.87", true},
+		{"Firefox/128.0", false},
+		{"", false},
+	} {
+		if got := isChromium(test.product); got != test.want {
+			t.Errorf("isChromium(%q) = %v, want %v", test.product, got, test.want)
+		}
+	}
+}
+
+func TestCheckBrowser(t *testing.T) {
+	google := newFakeGoogle(t)
+	g := startTestBrowser(t, google)
+	v, err := g.browser.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isChromium(v.Product) {
+		t.Errorf("test browser %q not detected as Chromium", v.Product)
+	}
+	err = g.checkBrowser()
+	if err != nil {
+		t.Error(err)
+	}
+}
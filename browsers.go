@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// skipProfileDirs are the directories of the browser profile which
+// are caches so aren't worth copying for the other -browsers
+var skipProfileDirs = map[string]bool{
+	"Cache":             true,
+	"Code Cache":        true,
+	"GPUCache":          true,
+	"GrShaderCache":     true,
+	"ShaderCache":       true,
+	"GraphiteDawnCache": true,
+	"DawnCache":         true,
+	"CacheStorage":      true,
+	"Crashpad":          true,
+}
+
+// workerProfileDir returns the profile directory of -browsers
+// instance n
+func workerProfileDir(n int) string {
+	return filepath.Join(configRoot, "browsers", fmt.Sprintf("browser-%d", n))
+}
+
+// copyProfiles copies the logged in profile for each of the other
+// -browsers so they start logged in.
+//
+// The copies are refreshed on every start so they pick up the cookies
+// from the last -login.
+func copyProfiles() error {
+	for n := 1; n < *browsers; n++ {
+		dir := workerProfileDir(n)
+		err := copyProfile(browserConfig, dir)
+		if err != nil {
+			return fmt.Errorf("failed to copy browser profile for browser %d: %w", n, err)
+		}
+		slog.Debug("Copied browser profile", "browser", n, "profile", dir)
+	}
+	return nil
+}
+
+// copyProfile replaces dst with a copy of the browser profile in src
+// leaving out the caches and the lock files of a running browser.
+func copyProfile(src, dst string) error {
+	err := os.RemoveAll(dst)
+	if err != nil {
+		return err
+	}
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			if skipProfileDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, 0700)
+		}
+		// Singleton* are the symlinks and socket which lock the
+		// profile to a running browser
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), "Singleton") {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
+
+// copyFile copies src to dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// startWorkers starts the other -browsers, each checking it is logged
+// in, and puts all the browsers behind g.downloader.
+func (g *Gphotos) startWorkers() error {
+	if *browsers <= 1 {
+		return nil
+	}
+	pool := &browserPool{workers: []*Gphotos{g}}
+	for n := 1; n < *browsers; n++ {
+		w := &Gphotos{
+			done:       g.done,
+			stats:      g.stats,
+			history:    g.history,
+			shares:     g.shares,
+			profileDir: workerProfileDir(n),
+			tabs:       newTabPool(*tabPoolSize),
+		}
+		w.downloader = w
+		w.lastUsed.Store(time.Now().UnixNano())
+		var err error
+		w.downloadDir, err = makeWorkerDir(n)
+		if err != nil {
+			return err
+		}
+		slog.Info("Starting browser", "browser", n+1, "browsers", *browsers)
+		err = w.startBrowser()
+		if err != nil {
+			_ = os.RemoveAll(w.downloadDir)
+			return fmt.Errorf("browser %d: %w", n+1, err)
+		}
+		g.workers = append(g.workers, w)
+		err = w.fillTabPool()
+		if err != nil {
+			return fmt.Errorf("browser %d: %w", n+1, err)
+		}
+		pool.workers = append(pool.workers, w)
+	}
+	pool.busy = make([]atomic.Int64, len(pool.workers))
+	g.pool = pool
+	g.downloader = pool
+	return nil
+}
+
+// closeWorkers closes the other -browsers
+func (g *Gphotos) closeWorkers() {
+	for _, w := range g.workers {
+		w.life.Lock()
+		if !w.asleep.Load() {
+			w.closeBrowser()
+		}
+		w.life.Unlock()
+		err := os.RemoveAll(w.downloadDir)
+		if err != nil {
+			slog.Error("Failed to remove download directory partition", "dir", w.downloadDir, "err", err)
+		}
+	}
+	g.workers = nil
+	g.pool = nil
+}
+
+// browserPool sends each download to the browser with the fewest
+// downloads in progress or waiting for it.
+type browserPool struct {
+	workers []*Gphotos
+	busy    []atomic.Int64 // downloads assigned to each of workers
+}
+
+// check *browserPool satisfies the interface
+var _ downloader = (*browserPool)(nil)
+
+// pick returns the index of the least busy browser, passing over those
+// which are restarting unless they all are
+func (p *browserPool) pick() int {
+	best, bestRestarting := -1, false
+	for i, w := range p.workers {
+		restarting, _ := w.restart.retryAfter()
+		switch {
+		case best < 0,
+			bestRestarting && !restarting,
+			restarting == bestRestarting && p.busy[i].Load() < p.busy[best].Load():
+			best, bestRestarting = i, restarting
+		}
+	}
+	return best
+}
+
+// metaBrowser returns the least busy of the -browsers for a metadata
+// request to use
+func (g *Gphotos) metaBrowser() *Gphotos {
+	if g.pool == nil {
+		return g
+	}
+	return g.pool.workers[g.pool.pick()]
+}
+
+// Download photoID on the least busy browser
+func (p *browserPool) Download(photoID string) (string, error) {
+	return p.DownloadWithProgress(photoID, nil)
+}
+
+// DownloadWithProgress downloads photoID on the least busy browser
+// calling report as the download proceeds.
+func (p *browserPool) DownloadWithProgress(photoID string, report func(progress)) (string, error) {
+	return p.DownloadFrame(photoID, 0, report)
+}
+
+// DownloadFrame downloads frame of photoID on the least busy browser
+// calling report as the download proceeds.
+func (p *browserPool) DownloadFrame(photoID string, frame int, report func(progress)) (string, error) {
+	i := p.pick()
+	p.busy[i].Add(1)
+	defer p.busy[i].Add(-1)
+	return p.workers[i].DownloadFrame(photoID, frame, report)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// A browser which fails to start doesn't leave its download
+// directory behind
+func TestStartWorkersFails(t *testing.T) {
+	g := newTestGphotos(t, newFakeDownloader(t))
+	setFlag(t, "browsers", "2")
+	oldPath := browserPath
+	browserPath = filepath.Join(t.TempDir(), "no-such-browser")
+	t.Cleanup(func() {
+		browserPath = oldPath
+	})
+
+	err := g.startWorkers()
+	if err == nil {
+		t.Fatal("started a missing browser")
+	}
+	if len(g.workers) != 0 {
+		t.Errorf("%d workers after failing to start, want 0", len(g.workers))
+	}
+	checkEmptyDir(t, downloadDir)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// casPath returns the path in -cas-dir for a file with the SHA-256
+// sum given and extension ext, sharded into directories by the first
+// two bytes of the sum, eg ab/cd/abcd...jpg
+func casPath(sum, ext string) string {
+	return filepath.Join(*casDir, sum[0:2], sum[2:4], sum+ext)
+}
+
+// casExt returns the extension to store a file in -cas-dir with, from
+// its original filename or if that has none its contentType.
+func casExt(filename, contentType string) string {
+	if ext := strings.ToLower(filepath.Ext(filename)); ext != "" {
+		return ext
+	}
+	base, _, _ := strings.Cut(contentType, ";")
+	exts, err := mime.ExtensionsByType(base)
+	if err != nil || len(exts) == 0 {
+		return ""
+	}
+	return exts[0]
+}
+
+// storeCAS moves the file at path with SHA-256 sum into -cas-dir
+// returning where it is stored.
+//
+// If the same content is already stored the file is removed instead
+// so each photo is only stored once however often it is fetched.
+func storeCAS(path, sum, ext string) (string, error) {
+	dest := casPath(sum, ext)
+	_, err := os.Stat(dest)
+	if err == nil {
+		return dest, os.Remove(path)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	err = os.MkdirAll(filepath.Dir(dest), 0777)
+	if err != nil {
+		return "", err
+	}
+	// Move into place under a temporary name, unique to this
+	// download, so a partial copy is never seen at dest
+	tmp := dest + "." + filepath.Base(path) + ".partial"
+	err = moveFile(path, tmp)
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(tmp, dest)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	return dest, nil
+}
+
+// finishDownload stores the downloaded photo at path in -cas-dir if
+// set and its SHA-256 sum is known, otherwise it removes it with
+// removeDownload.
+func finishDownload(slog *slog.Logger, photoID, path, sum, ext string) {
+	if *casDir == "" || sum == "" {
+		removeDownload(slog, photoID, path)
+		return
+	}
+	dest, err := storeCAS(path, sum, ext)
+	if err != nil {
+		slog.Error("Failed to store photo in -cas-dir", "path", path, "err", err)
+		_ = os.Remove(path)
+		return
+	}
+	slog.Info("Stored photo", "path", dest, "sha256", sum)
+}
@@ -0,0 +1,195 @@
+// Package client is a Go client for the gphotosdl HTTP API
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultURL is the address gphotosdl listens on by default
+const DefaultURL = "http://localhost:8282"
+
+// Errors returned for the HTTP statuses the server uses. Use
+// errors.Is to check for them.
+var (
+	ErrBadRequest  = errors.New("bad request")
+	ErrForbidden   = errors.New("forbidden")
+	ErrNotFound    = errors.New("photo not found")
+	ErrUnavailable = errors.New("service unavailable")
+)
+
+// Error codes the server returns in Error.Code
+const (
+	CodeBadRequest        = "bad_request"
+	CodeUnauthorized      = "unauthorized"
+	CodeForbidden         = "forbidden"
+	CodeNotAuthenticated  = "not_authenticated"
+	CodeAccountSuspended  = "account_suspended"
+	CodeStorageFull       = "storage_full"
+	CodePhotoNotFound     = "photo_not_found"
+	CodeLockedFolder      = "locked_folder"
+	CodeTooLarge          = "too_large"
+	CodeRateLimited       = "rate_limited"
+	CodeDownloadTimeout   = "download_timeout"
+	CodeBrowserRestarting = "browser_restarting"
+	CodeQueueFull         = "queue_full"
+	CodeCircuitOpen       = "circuit_open"
+	CodeDisabled          = "disabled"
+	CodeInternal          = "internal_error"
+)
+
+// Error is returned when the server responds with an error status
+type Error struct {
+	StatusCode int    // HTTP status code
+	Status     string // HTTP status line
+	Code       string // error code from the server, eg CodePhotoNotFound, if sent
+	Message    string // error message from the server, if sent
+}
+
+// Error satisfies the error interface
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("gphotosdl: %s: %s", e.Status, e.Message)
+	}
+	return fmt.Sprintf("gphotosdl: %s", e.Status)
+}
+
+// Is makes errors.Is match the sentinel error for the status code
+func (e *Error) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return target == ErrBadRequest
+	case http.StatusForbidden:
+		return target == ErrForbidden
+	case http.StatusNotFound, http.StatusGone:
+		return target == ErrNotFound
+	case http.StatusServiceUnavailable:
+		return target == ErrUnavailable
+	}
+	return false
+}
+
+// Meta is the metadata for a photo
+type Meta struct {
+	ID          string `json:"id"`
+	Filename    string `json:"filename,omitempty"`
+	Date        string `json:"date,omitempty"`
+	Size        string `json:"size,omitempty"`
+	Dimensions  string `json:"dimensions,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Health is the health of the server
+type Health struct {
+	Status  string `json:"status"`
+	Browser string `json:"browser,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Client talks to a gphotosdl server
+type Client struct {
+	base string
+	http *http.Client
+}
+
+// New makes a Client for the server at baseURL, eg DefaultURL.
+//
+// If httpClient is nil then http.DefaultClient is used.
+func New(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		base: strings.TrimRight(baseURL, "/"),
+		http: httpClient,
+	}
+}
+
+// get does a GET request for path returning the response if it was
+// successful.
+//
+// The caller must close the response body.
+func (c *Client) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &Error{StatusCode: resp.StatusCode, Status: resp.Status}
+		// Read the code and message from the JSON body if there is one
+		var body struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") &&
+			json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&body) == nil {
+			apiErr.Code = body.Code
+			apiErr.Message = body.Error
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// getJSON does a GET request for path decoding the response into v
+func (c *Client) getJSON(ctx context.Context, path string, v any) error {
+	resp, err := c.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("gphotosdl: failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// Download the photo with the ID given.
+//
+// The photo is streamed from the response and the caller must close
+// the returned io.ReadCloser.
+func (c *Client) Download(ctx context.Context, photoID string) (io.ReadCloser, error) {
+	resp, err := c.get(ctx, "/id/"+url.PathEscape(photoID))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Meta reads the metadata for the photo with the ID given
+func (c *Client) Meta(ctx context.Context, photoID string) (*Meta, error) {
+	var meta Meta
+	err := c.getJSON(ctx, "/id/"+url.PathEscape(photoID)+"/meta", &meta)
+	if err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// Health checks the server is healthy.
+//
+// An unhealthy server returns an error matching ErrUnavailable.
+func (c *Client) Health(ctx context.Context) (*Health, error) {
+	var health Health
+	err := c.getJSON(ctx, "/health", &health)
+	if err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
@@ -0,0 +1,160 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+const testPhoto = "not really a jpeg"
+
+// newTestServer starts a server answering like gphotosdl. /health
+// fails while unhealthy is set.
+func newTestServer(t *testing.T, unhealthy *atomic.Bool) *httptest.Server {
+	t.Helper()
+	writeJSON := func(w http.ResponseWriter, status int, body string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = io.WriteString(w, body)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /id/{photoID}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.PathValue("photoID") {
+		case "photo1":
+			w.Header().Set("Content-Type", "image/jpeg")
+			_, _ = io.WriteString(w, testPhoto)
+		case "bad id":
+			http.Error(w, "malformed photo ID", http.StatusBadRequest)
+		default:
+			writeJSON(w, http.StatusNotFound, `{"error":"photo not found","code":"photo_not_found"}`)
+		}
+	})
+	mux.HandleFunc("GET /id/{photoID}/meta", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("photoID") != "photo1" {
+			writeJSON(w, http.StatusNotFound, `{"error":"photo not found","code":"photo_not_found"}`)
+			return
+		}
+		writeJSON(w, http.StatusOK, `{"id":"photo1","filename":"IMG_0001.jpg","size":"2 MB","dimensions":"4032 x 3024"}`)
+	})
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		if unhealthy.Load() {
+			writeJSON(w, http.StatusServiceUnavailable, `{"status":"browser down","error":"browser closed"}`)
+			return
+		}
+		writeJSON(w, http.StatusOK, `{"status":"ok","browser":"HeadlessChrome/140.0.7339.207"}`)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDownload(t *testing.T) {
+	srv := newTestServer(t, new(atomic.Bool))
+	// A trailing / on the URL is allowed
+	c := New(srv.URL+"/", nil)
+	ctx := context.Background()
+
+	rc, err := c.Download(ctx, "photo1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = rc.Close()
+	if string(data) != testPhoto {
+		t.Errorf("got %q, want %q", data, testPhoto)
+	}
+
+	_, err = c.Download(ctx, "missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got %T, want *Error", err)
+	}
+	if apiErr.StatusCode != http.StatusNotFound || apiErr.Code != CodePhotoNotFound || apiErr.Message != "photo not found" {
+		t.Errorf("got %+v", apiErr)
+	}
+
+	// The photo ID is escaped and an error without a JSON body
+	// still has the status
+	_, err = c.Download(ctx, "bad id")
+	if !errors.Is(err, ErrBadRequest) {
+		t.Errorf("got %v, want ErrBadRequest", err)
+	}
+	if errors.As(err, &apiErr) && (apiErr.Code != "" || apiErr.Message != "") {
+		t.Errorf("got code %q message %q from a text error", apiErr.Code, apiErr.Message)
+	}
+}
+
+func TestMeta(t *testing.T) {
+	srv := newTestServer(t, new(atomic.Bool))
+	c := New(srv.URL, nil)
+	ctx := context.Background()
+
+	meta, err := c.Meta(ctx, "photo1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Meta{ID: "photo1", Filename: "IMG_0001.jpg", Size: "2 MB", Dimensions: "4032 x 3024"}
+	if *meta != want {
+		t.Errorf("got %+v, want %+v", *meta, want)
+	}
+
+	_, err = c.Meta(ctx, "missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	var unhealthy atomic.Bool
+	srv := newTestServer(t, &unhealthy)
+	c := New(srv.URL, srv.Client())
+	ctx := context.Background()
+
+	health, err := c.Health(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if health.Status != "ok" || health.Browser == "" {
+		t.Errorf("got %+v", health)
+	}
+
+	unhealthy.Store(true)
+	_, err = c.Health(ctx)
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf("got %v, want ErrUnavailable", err)
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Errorf("%v matches ErrNotFound", err)
+	}
+}
+
+func TestErrorIs(t *testing.T) {
+	for _, test := range []struct {
+		status int
+		want   error
+	}{
+		{http.StatusBadRequest, ErrBadRequest},
+		{http.StatusForbidden, ErrForbidden},
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusGone, ErrNotFound},
+		{http.StatusServiceUnavailable, ErrUnavailable},
+		{http.StatusInternalServerError, nil},
+	} {
+		err := &Error{StatusCode: test.status, Status: http.StatusText(test.status)}
+		for _, sentinel := range []error{ErrBadRequest, ErrForbidden, ErrNotFound, ErrUnavailable} {
+			if got := errors.Is(err, sentinel); got != (sentinel == test.want) {
+				t.Errorf("status %d: errors.Is(%v) = %v", test.status, sentinel, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// trustedProxies are the proxies whose X-Forwarded-For headers are
+// believed, set from -trusted-proxies
+var trustedProxies []netip.Prefix
+
+// peerIP returns the IP address of the immediate peer making r
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP returns the IP address of the client making r.
+//
+// If the peer is one of the -trusted-proxies then the client is read
+// from the X-Forwarded-For header, taking the rightmost address which
+// isn't a trusted proxy, or failing that X-Real-IP. The headers are
+// ignored from other peers so they can't be spoofed.
+func clientIP(r *http.Request) string {
+	ip := peerIP(r)
+	if len(trustedProxies) == 0 || !containsIP(trustedProxies, ip) {
+		return ip
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+			if !containsIP(trustedProxies, hop) {
+				return hop
+			}
+			ip = hop
+		}
+		return ip
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+	return ip
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"compress/gzip"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// gzipResponseWriter compresses the response written to it
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+	noBody      bool // set if the status doesn't allow a body
+}
+
+// WriteHeader marks the response as compressed before sending the
+// headers
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.noBody = status == http.StatusNoContent || status == http.StatusNotModified || status < 200
+	if !w.noBody {
+		h := w.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		h.Add("Vary", "Accept-Encoding")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write compresses p
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.noBody {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.gz.Write(p)
+}
+
+// Flush sends the data compressed so far to the client
+func (w *gzipResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.noBody {
+		_ = w.gz.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// acceptsGzip returns true if the client accepts gzip responses
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// compress wraps next to gzip its response if -compress is set and
+// the client accepts it.
+//
+// Only use this for text responses - photos and videos are already
+// compressed.
+func compress(next http.HandlerFunc) http.HandlerFunc {
+	if !*compressJSON {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r) || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+		gz := gzip.NewWriter(w)
+		gw := &gzipResponseWriter{ResponseWriter: w, gz: gz}
+		next(gw, r)
+		if gw.noBody {
+			return
+		}
+		if !gw.wroteHeader {
+			gw.WriteHeader(http.StatusOK)
+		}
+		err := gz.Close()
+		if err != nil {
+			slog.Debug("Failed to finish compressed response", "path", r.URL.Path, "err", err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// consentURL is where Google sends browsers which need to accept
+// cookies before continuing
+const consentURL = "https://consent.google.com/"
+
+// dismissConsent clicks through the cookie consent interstitial if
+// page is showing it, returning true if it was dismissed.
+//
+// The buttons are found with the comma separated CSS selectors in
+// -consent-selector which are tried in order.
+func dismissConsent(page *rod.Page, slog *slog.Logger) (bool, error) {
+	info, err := page.Info()
+	if err != nil {
+		return false, fmt.Errorf("failed to read page info: %w", err)
+	}
+	onConsentPage := strings.HasPrefix(info.URL, consentURL)
+	for _, selector := range strings.Split(*consentSelector, ",") {
+		selector = strings.TrimSpace(selector)
+		if selector == "" {
+			continue
+		}
+		has, el, err := page.Has(selector)
+		if err != nil {
+			return false, fmt.Errorf("failed to look for consent button: %w", err)
+		}
+		if !has {
+			continue
+		}
+		slog.Debug("Found consent button", "selector", selector)
+		err = el.Click(proto.InputMouseButtonLeft, 1)
+		if err != nil {
+			return false, fmt.Errorf("failed to click consent button: %w", err)
+		}
+		err = page.WaitStable(time.Second)
+		if err != nil {
+			return false, fmt.Errorf("page load after consent: %w", err)
+		}
+		slog.Info("Dismissed consent banner", "url", info.URL)
+		return true, nil
+	}
+	if onConsentPage {
+		return false, fmt.Errorf("stuck on consent page %q - set -consent-selector to match its accept button", info.URL)
+	}
+	return false, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+)
+
+// ftypBrands maps the ISO base media file brands which
+// http.DetectContentType doesn't know to their MIME types
+var ftypBrands = map[string]string{
+	"heic": "image/heic",
+	"heix": "image/heic",
+	"hevc": "image/heic-sequence",
+	"mif1": "image/heif",
+	"msf1": "image/heif-sequence",
+	"avif": "image/avif",
+	"qt  ": "video/quicktime",
+	"3gp4": "video/3gpp",
+	"3gp5": "video/3gpp",
+	"3g2a": "video/3gpp2",
+}
+
+// sniffContentType returns the MIME type of the file at path from its
+// first 512 bytes.
+//
+// The downloaded files are named by GUID without an extension so
+// http.ServeFile can't work it out.
+func sniffContentType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	buf = buf[:n]
+
+	// ISO base media files have "ftyp" then the brand at offset 4
+	if len(buf) >= 12 && bytes.Equal(buf[4:8], []byte("ftyp")) {
+		if contentType, ok := ftypBrands[string(buf[8:12])]; ok {
+			return contentType, nil
+		}
+	}
+	return http.DetectContentType(buf), nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// cookieJSON is a cookie as exported by browser extensions such as
+// "Cookie-Editor" or "EditThisCookie"
+type cookieJSON struct {
+	Domain         string  `json:"domain"`
+	Name           string  `json:"name"`
+	Value          string  `json:"value"`
+	Path           string  `json:"path"`
+	Secure         bool    `json:"secure"`
+	HTTPOnly       bool    `json:"httpOnly"`
+	SameSite       string  `json:"sameSite"`
+	ExpirationDate float64 `json:"expirationDate"`
+	Expires        float64 `json:"expires"` // used by the DevTools protocol and Playwright
+}
+
+// cookieSameSite maps the sameSite values of the exports to the
+// DevTools protocol
+var cookieSameSite = map[string]proto.NetworkCookieSameSite{
+	"strict":         proto.NetworkCookieSameSiteStrict,
+	"lax":            proto.NetworkCookieSameSiteLax,
+	"none":           proto.NetworkCookieSameSiteNone,
+	"no_restriction": proto.NetworkCookieSameSiteNone,
+}
+
+// loadCookies reads the cookies in the -cookies-file which may be
+// JSON or in the Netscape cookies.txt format
+func loadCookies(path string) ([]*proto.NetworkCookieParam, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read -cookies-file: %w", err)
+	}
+	var cookies []*proto.NetworkCookieParam
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		cookies, err = parseJSONCookies(trimmed)
+	} else {
+		cookies, err = parseNetscapeCookies(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse -cookies-file %q: %w", path, err)
+	}
+	if len(cookies) == 0 {
+		return nil, fmt.Errorf("no cookies found in -cookies-file %q", path)
+	}
+	return cookies, nil
+}
+
+// parseJSONCookies parses a JSON array of cookies
+func parseJSONCookies(data []byte) ([]*proto.NetworkCookieParam, error) {
+	var in []cookieJSON
+	err := json.Unmarshal(data, &in)
+	if err != nil {
+		return nil, err
+	}
+	cookies := make([]*proto.NetworkCookieParam, 0, len(in))
+	for i, c := range in {
+		if c.Name == "" || c.Domain == "" {
+			return nil, fmt.Errorf("cookie %d: needs a name and domain", i+1)
+		}
+		expires := c.ExpirationDate
+		if expires == 0 {
+			expires = c.Expires
+		}
+		cookies = append(cookies, &proto.NetworkCookieParam{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HTTPOnly: c.HTTPOnly,
+			SameSite: cookieSameSite[strings.ToLower(c.SameSite)],
+			Expires:  proto.TimeSinceEpoch(max(expires, 0)),
+		})
+	}
+	return cookies, nil
+}
+
+// parseNetscapeCookies parses the tab separated Netscape cookies.txt
+// format written by curl, yt-dlp and browser extensions
+func parseNetscapeCookies(data []byte) ([]*proto.NetworkCookieParam, error) {
+	var cookies []*proto.NetworkCookieParam
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// HttpOnly cookies are marked with a prefix on the domain
+		line, httpOnly := strings.CutPrefix(line, "#HttpOnly_")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %d: expecting 7 tab separated fields but got %d", lineNumber, len(fields))
+		}
+		expires, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad expiry %q", lineNumber, fields[4])
+		}
+		cookies = append(cookies, &proto.NetworkCookieParam{
+			Domain:   fields[0],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Expires:  proto.TimeSinceEpoch(max(expires, 0)),
+			Name:     fields[5],
+			Value:    fields[6],
+			HTTPOnly: httpOnly,
+		})
+	}
+	return cookies, scanner.Err()
+}
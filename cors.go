@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsOrigins parses the -cors-origin flag into a set of allowed
+// origins. It returns nil if CORS is disabled.
+func corsOrigins() map[string]bool {
+	if *corsOrigin == "" {
+		return nil
+	}
+	origins := map[string]bool{}
+	for _, origin := range strings.Split(*corsOrigin, ",") {
+		origin = strings.TrimSpace(origin)
+		if origin != "" {
+			origins[origin] = true
+		}
+	}
+	return origins
+}
+
+// cors wraps next adding CORS headers for allowed origins and
+// answering preflight OPTIONS requests.
+//
+// If -cors-origin isn't set then next is returned unchanged.
+func cors(next http.Handler) http.Handler {
+	origins := corsOrigins()
+	if origins == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && (origins["*"] || origins[origin])
+		if allowed {
+			h := w.Header()
+			if origins["*"] {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+				h.Add("Vary", "Origin")
+			}
+		}
+		// Answer preflight requests here as the mux doesn't route OPTIONS
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			h := w.Header()
+			h.Set("Access-Control-Allow-Methods", "GET, HEAD, POST, OPTIONS")
+			if reqHeaders := r.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+				h.Set("Access-Control-Allow-Headers", reqHeaders)
+			}
+			h.Set("Access-Control-Max-Age", "3600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// tabJSON describes a browser target for GET /debug/tabs
+type tabJSON struct {
+	Browser  int    `json:"browser"` // index of the -browsers instance
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	URL      string `json:"url"`
+	Title    string `json:"title,omitempty"`
+	Attached bool   `json:"attached"`
+}
+
+// Serve the list of targets open in the browsers - for debugging tab
+// leaks.
+//
+// This needs -debug or the -admin-token.
+func (g *Gphotos) getDebugTabs(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("got debug tabs request")
+	if !*debug && !checkAdmin(w, r) {
+		return
+	}
+	tabs := []tabJSON{}
+	for i, b := range append([]*Gphotos{g}, g.workers...) {
+		if !b.rlockAwake() {
+			continue
+		}
+		res, err := proto.TargetGetTargets{}.Call(b.browser.Timeout(10 * time.Second))
+		b.life.RUnlock()
+		if err != nil {
+			slog.Error("Failed to list browser tabs", "browser", i, "err", err)
+			writeError(w, err)
+			return
+		}
+		for _, t := range res.TargetInfos {
+			tabs = append(tabs, tabJSON{
+				Browser:  i,
+				ID:       string(t.TargetID),
+				Type:     string(t.Type),
+				URL:      t.URL,
+				Title:    t.Title,
+				Attached: t.Attached,
+			})
+		}
+	}
+	writeJSON(w, http.StatusOK, tabs)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// deepHealthTimeout is the longest the -health-deep probe may take
+const deepHealthTimeout = 30 * time.Second
+
+// Results of the -health-deep probe, also used as the /health status
+const (
+	healthOK               = "ok"
+	healthBrowserDown      = "browser down"
+	healthNotAuthenticated = "not authenticated"
+	healthUnreachable      = "google unreachable"
+)
+
+// deepHealth is the result of the last -health-deep probe, which is
+// reused for -health-deep-interval so /health doesn't hammer Google.
+type deepHealth struct {
+	mu      sync.Mutex // held while probing so only one runs at once
+	checked time.Time  // when the probe last ran, zero if never
+	status  string
+	url     string
+	err     error
+	elapsed time.Duration
+}
+
+// deepCheck returns the result of the -health-deep probe, running it
+// if the last result is older than -health-deep-interval.
+func (g *Gphotos) deepCheck() (status, url string, checked time.Time, err error) {
+	d := &g.deep
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.checked.IsZero() || time.Since(d.checked) >= *healthDeepTTL {
+		start := time.Now()
+		d.status, d.url, d.err = g.probeGoogle()
+		d.checked = time.Now()
+		d.elapsed = time.Since(start)
+		if d.status == healthOK {
+			slog.Debug("Deep health check passed", "url", d.url, "elapsed", d.elapsed)
+		} else {
+			slog.Error("Deep health check failed", "status", d.status, "url", d.url, "elapsed", d.elapsed, "err", d.err)
+		}
+	}
+	return d.status, d.url, d.checked, d.err
+}
+
+// probeGoogle opens Google Photos in a new tab to check it can be
+// reached and the browser is logged in.
+func (g *Gphotos) probeGoogle() (status, url string, err error) {
+	unlock, err := g.lockMeta()
+	if err != nil {
+		return healthBrowserDown, "", err
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), deepHealthTimeout)
+	defer cancel()
+
+	page, err := g.newTab(ctx)
+	if err != nil {
+		return healthBrowserDown, "", fmt.Errorf("failed to open browser tab: %w", err)
+	}
+	defer g.closeTab(page)
+
+	// Watch for the response to the page
+	code := 0
+	waitResponse := page.EachEvent(func(e *proto.NetworkResponseReceived) bool {
+		if e.Type != proto.NetworkResourceTypeDocument {
+			return false
+		}
+		code = e.Response.Status
+		return true
+	})
+
+	err = navigate(page, slog.Default(), *gphotosURL)
+	if err == nil {
+		waitResponse()
+		err = waitPage(page)
+	}
+	var navErr *rod.NavigationError
+	if errors.As(err, &navErr) || errors.Is(err, context.DeadlineExceeded) {
+		return healthUnreachable, "", err
+	} else if err != nil {
+		return healthBrowserDown, "", err
+	}
+	if code >= 500 {
+		return healthUnreachable, "", fmt.Errorf("google photos returned HTTP status %d", code)
+	}
+	info, err := page.Info()
+	if err != nil {
+		return healthBrowserDown, "", fmt.Errorf("failed to read page info: %w", err)
+	}
+	if loginStage(info.URL) != stageSignedIn {
+		return healthNotAuthenticated, info.URL, errors.New("google photos redirected away - browser is not logged in, rerun with the -login flag")
+	}
+	return healthOK, info.URL, nil
+}
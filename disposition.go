@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// Values for the disposition parameter of GET /id/{photoID}
+const (
+	dispositionInline     = "inline"
+	dispositionAttachment = "attachment"
+)
+
+// downloadNamed downloads frame of photoID, 0 for the default,
+// returning the path and the file name the browser suggested for it,
+// which is "" if it didn't say.
+func (g *Gphotos) downloadNamed(photoID string, frame int) (path, filename string, err error) {
+	var name atomic.Pointer[string]
+	path, err = g.downloader.DownloadFrame(photoID, frame, func(p progress) {
+		if p.Stage == stageDownloading && p.Filename != "" {
+			filename := filepath.Base(p.Filename)
+			name.Store(&filename)
+		}
+	})
+	if n := name.Load(); n != nil {
+		filename = *n
+	}
+	return path, filename, err
+}
+
+// checkDisposition reads the disposition parameter of r writing a 400
+// error and returning false if it is invalid.
+func checkDisposition(w http.ResponseWriter, r *http.Request) (string, bool) {
+	disposition := r.URL.Query().Get("disposition")
+	switch disposition {
+	case "", dispositionInline, dispositionAttachment:
+		return disposition, true
+	}
+	writeErrorCode(w, codeBadRequest, fmt.Sprintf("invalid disposition %q - expecting %s or %s", disposition, dispositionInline, dispositionAttachment))
+	return "", false
+}
+
+// contentDisposition returns the Content-Disposition header for a
+// file called filename of contentType.
+//
+// If disposition is empty images and videos are inline so they can be
+// shown in the browser and anything else is an attachment.
+func contentDisposition(disposition, contentType, filename string) string {
+	if disposition == "" {
+		disposition = dispositionAttachment
+		if strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "video/") {
+			disposition = dispositionInline
+		}
+	}
+	if filename == "" {
+		return disposition
+	}
+	return mime.FormatMediaType(disposition, map[string]string{"filename": filename})
+}
@@ -0,0 +1,22 @@
+package main
+
+// downloader fetches photos into local files.
+//
+// The HTTP handlers download through this so they can be exercised
+// with a fake instead of a real browser. *Gphotos is the browser
+// backed implementation.
+type downloader interface {
+	// Download photoID returning the path of the downloaded file
+	// which the caller should remove.
+	Download(photoID string) (string, error)
+	// DownloadWithProgress is Download calling report with
+	// progress events as it goes.
+	DownloadWithProgress(photoID string, report func(progress)) (string, error)
+	// DownloadFrame is DownloadWithProgress for frame of a burst
+	// or stack, numbered from 1, or whatever the download key
+	// gives if frame is 0.
+	DownloadFrame(photoID string, frame int, report func(progress)) (string, error)
+}
+
+// check *Gphotos satisfies the interface
+var _ downloader = (*Gphotos)(nil)
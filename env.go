@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix is prepended to the upper cased flag name to make the
+// environment variable name, eg -log-file is GPHOTOSDL_LOG_FILE
+const envPrefix = "GPHOTOSDL_"
+
+// envName returns the environment variable name for the flag name
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// isBoolFlag returns true if f is a boolean flag
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// parseBoolEnv converts the boolean forms accepted in the environment
+// into ones flag understands.
+func parseBoolEnv(value string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "t", "true", "y", "yes", "on":
+		return "true", nil
+	case "", "0", "f", "false", "n", "no", "off":
+		return "false", nil
+	}
+	return "", fmt.Errorf("invalid boolean %q", value)
+}
+
+// setFlagsFromEnv sets the flags from their environment variables.
+//
+// This must be called before flag.Parse so that flags on the command
+// line take precedence.
+func setFlagsFromEnv() error {
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		name := envName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if isBoolFlag(f) {
+			value, err = parseBoolEnv(value)
+			if err != nil {
+				err = fmt.Errorf("environment variable %s: %w", name, err)
+				return
+			}
+		}
+		setErr := f.Value.Set(value)
+		if setErr != nil {
+			err = fmt.Errorf("environment variable %s: %w", name, setErr)
+		}
+	})
+	return err
+}
+
+// parseFlags parses args into the flags in fs over the values set from
+// the environment by setFlagsFromEnv.
+//
+// Repeatable flags given in args replace rather than add to the
+// values from the environment, like the other flags do.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	fromEnv := map[*stringList]stringList{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if list, ok := f.Value.(*stringList); ok {
+			fromEnv[list] = *list
+			*list = nil
+		}
+	})
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if list, ok := f.Value.(*stringList); ok {
+			delete(fromEnv, list)
+		}
+	})
+	for list, values := range fromEnv {
+		*list = values
+	}
+	return nil
+}
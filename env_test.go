@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"slices"
+	"testing"
+)
+
+func TestParseFlagsListsReplaceEnv(t *testing.T) {
+	var addrs, chromeFlags, tokens stringList
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&addrs, "addr", "")
+	fs.Var(&chromeFlags, "chrome-flag", "")
+	fs.Var(&tokens, "addr-token", "")
+	name := fs.String("name", "", "")
+
+	// As set by setFlagsFromEnv
+	for _, f := range []*stringList{&addrs, &chromeFlags, &tokens} {
+		_ = f.Set("from-env")
+	}
+	*name = "from-env"
+
+	err := parseFlags(fs, []string{"-addr", "a", "-addr", "b", "-chrome-flag", "--c", "-name", "n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		flag string
+		got  stringList
+		want stringList
+	}{
+		{"addr", addrs, stringList{"a", "b"}},
+		{"chrome-flag", chromeFlags, stringList{"--c"}},
+		{"addr-token", tokens, stringList{"from-env"}},
+	} {
+		if !slices.Equal(test.got, test.want) {
+			t.Errorf("-%s %q, want %q", test.flag, test.got, test.want)
+		}
+	}
+	if *name != "n" {
+		t.Errorf("-name %q, want n", *name)
+	}
+
+	err = parseFlags(fs, []string{"-bad"})
+	if err == nil {
+		t.Error("no error for an unknown flag")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Error codes returned in the JSON error body so clients can tell
+// failures apart without parsing messages. These are part of the API
+// so don't change them.
+const (
+	codeBadRequest        = "bad_request"        // malformed photo ID or parameters
+	codeUnauthorized      = "unauthorized"       // the -admin-token or -addr-token is missing or wrong
+	codeForbidden         = "forbidden"          // not in the allowlist or no access to a share
+	codeNotAuthenticated  = "not_authenticated"  // the browser isn't logged in to Google
+	codeAccountSuspended  = "account_suspended"  // the Google account is disabled
+	codeStorageFull       = "storage_full"       // the Google account is out of storage
+	codePhotoNotFound     = "photo_not_found"    // the photo doesn't exist
+	codeLockedFolder      = "locked_folder"      // the Locked Folder isn't unlocked
+	codeTooLarge          = "too_large"          // the photo is bigger than -max-file-size
+	codeRateLimited       = "rate_limited"       // the client is over -rate-limit
+	codeDownloadTimeout   = "download_timeout"   // the download took longer than -download-timeout
+	codeBrowserRestarting = "browser_restarting" // the browser is restarting
+	codeQueueFull         = "queue_full"         // -max-queue downloads are waiting
+	codeCircuitOpen       = "circuit_open"       // downloads are failing so aren't being tried
+	codeDisabled          = "disabled"           // the endpoint is turned off by the config
+	codeInternal          = "internal_error"     // anything else
+)
+
+// codeStatus is the HTTP status for each error code
+var codeStatus = map[string]int{
+	codeBadRequest:        http.StatusBadRequest,
+	codeUnauthorized:      http.StatusUnauthorized,
+	codeForbidden:         http.StatusForbidden,
+	codeNotAuthenticated:  http.StatusServiceUnavailable,
+	codeAccountSuspended:  http.StatusForbidden,
+	codeStorageFull:       http.StatusPaymentRequired,
+	codePhotoNotFound:     http.StatusNotFound,
+	codeLockedFolder:      http.StatusForbidden,
+	codeTooLarge:          http.StatusRequestEntityTooLarge,
+	codeRateLimited:       http.StatusTooManyRequests,
+	codeDownloadTimeout:   http.StatusGatewayTimeout,
+	codeBrowserRestarting: http.StatusServiceUnavailable,
+	codeQueueFull:         http.StatusServiceUnavailable,
+	codeCircuitOpen:       http.StatusServiceUnavailable,
+	codeDisabled:          http.StatusMethodNotAllowed,
+	codeInternal:          http.StatusInternalServerError,
+}
+
+// statusCode is the error code for errors which only carry a status
+var statusCode = map[int]string{
+	http.StatusBadRequest:            codeBadRequest,
+	http.StatusUnauthorized:          codeUnauthorized,
+	http.StatusForbidden:             codeForbidden,
+	http.StatusPaymentRequired:       codeStorageFull,
+	http.StatusNotFound:              codePhotoNotFound,
+	http.StatusRequestEntityTooLarge: codeTooLarge,
+	http.StatusTooManyRequests:       codeRateLimited,
+	http.StatusGatewayTimeout:        codeDownloadTimeout,
+}
+
+// codeError is an error with an error code
+type codeError struct {
+	code string
+	err  error
+}
+
+// Error returns the message of the wrapped error
+func (e *codeError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the wrapped error
+func (e *codeError) Unwrap() error {
+	return e.err
+}
+
+// withCode returns err with the error code given
+func withCode(code string, err error) error {
+	return &codeError{code: code, err: err}
+}
+
+// errorCode returns the error code for err
+func errorCode(err error) string {
+	var ce *codeError
+	if errors.As(err, &ce) {
+		return ce.code
+	}
+	var h httpError
+	if errors.As(err, &h) {
+		if code, ok := statusCode[int(h)]; ok {
+			return code
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return codeDownloadTimeout
+	}
+	return codeInternal
+}
+
+// retryError is an error for a request which may be retried later
+type retryError struct {
+	after time.Duration
+	err   error
+}
+
+// Error returns the message of the wrapped error
+func (e *retryError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the wrapped error
+func (e *retryError) Unwrap() error {
+	return e.err
+}
+
+// withRetryAfter returns err telling the client to retry after the
+// duration given with a Retry-After header
+func withRetryAfter(after time.Duration, err error) error {
+	return &retryError{after: after, err: err}
+}
+
+// errorJSON is the JSON body of an error response
+type errorJSON struct {
+	Error  string `json:"error"`
+	Code   string `json:"code"`
+	Status int    `json:"status"`
+}
+
+// writeError writes err as a JSON error response with its status
+func writeError(w http.ResponseWriter, err error) {
+	var re *retryError
+	if errors.As(err, &re) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(max(re.after, time.Second).Seconds()))))
+	}
+	status := errorStatus(err)
+	writeJSON(w, status, errorJSON{Error: err.Error(), Code: errorCode(err), Status: status})
+}
+
+// writeErrorCode writes a JSON error response with message for code
+func writeErrorCode(w http.ResponseWriter, code, message string) {
+	status := codeStatus[code]
+	writeJSON(w, status, errorJSON{Error: message, Code: code, Status: status})
+}
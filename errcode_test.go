@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorCodeStatus(t *testing.T) {
+	for _, test := range []struct {
+		err    error
+		code   string
+		status int
+	}{
+		{withCode(codeBadRequest, errors.New("bad")), codeBadRequest, http.StatusBadRequest},
+		{withCode(codeUnauthorized, errors.New("no token")), codeUnauthorized, http.StatusUnauthorized},
+		{withCode(codeForbidden, errors.New("not allowed")), codeForbidden, http.StatusForbidden},
+		{withCode(codeNotAuthenticated, errors.New("logged out")), codeNotAuthenticated, http.StatusServiceUnavailable},
+		{withCode(codeAccountSuspended, errors.New("suspended")), codeAccountSuspended, http.StatusForbidden},
+		{withCode(codeStorageFull, errors.New("full")), codeStorageFull, http.StatusPaymentRequired},
+		{withCode(codePhotoNotFound, errors.New("missing")), codePhotoNotFound, http.StatusNotFound},
+		{withCode(codeLockedFolder, errors.New("locked")), codeLockedFolder, http.StatusForbidden},
+		{withCode(codeTooLarge, errors.New("big")), codeTooLarge, http.StatusRequestEntityTooLarge},
+		{withCode(codeRateLimited, errors.New("slow down")), codeRateLimited, http.StatusTooManyRequests},
+		{withCode(codeDownloadTimeout, errors.New("slow")), codeDownloadTimeout, http.StatusGatewayTimeout},
+		{withCode(codeBrowserRestarting, errors.New("restarting")), codeBrowserRestarting, http.StatusServiceUnavailable},
+		{withCode(codeQueueFull, errors.New("busy")), codeQueueFull, http.StatusServiceUnavailable},
+		{withCode(codeCircuitOpen, errors.New("open")), codeCircuitOpen, http.StatusServiceUnavailable},
+		{withCode(codeDisabled, errors.New("off")), codeDisabled, http.StatusMethodNotAllowed},
+		{withCode(codeInternal, errors.New("oops")), codeInternal, http.StatusInternalServerError},
+		// Wrapped errors keep their code
+		{fmt.Errorf("download: %w", withCode(codePhotoNotFound, errors.New("missing"))), codePhotoNotFound, http.StatusNotFound},
+		// Errors with just a status get the code for it
+		{httpError(http.StatusNotFound), codePhotoNotFound, http.StatusNotFound},
+		{fmt.Errorf("wrapped: %w", httpError(http.StatusPaymentRequired)), codeStorageFull, http.StatusPaymentRequired},
+		{httpError(http.StatusUnauthorized), codeUnauthorized, http.StatusUnauthorized},
+		// Statuses without a code are internal errors
+		{httpError(http.StatusTeapot), codeInternal, http.StatusTeapot},
+		{fmt.Errorf("wait: %w", context.DeadlineExceeded), codeDownloadTimeout, http.StatusGatewayTimeout},
+		{errors.New("other"), codeInternal, http.StatusInternalServerError},
+	} {
+		if got := errorCode(test.err); got != test.code {
+			t.Errorf("errorCode(%v) = %q, want %q", test.err, got, test.code)
+		}
+		if got := errorStatus(test.err); got != test.status {
+			t.Errorf("errorStatus(%v) = %d, want %d", test.err, got, test.status)
+		}
+	}
+}
+
+func TestCodeStatusComplete(t *testing.T) {
+	// Every status with a code must map back to a code with that status
+	for status, code := range statusCode {
+		if got := codeStatus[code]; got != status {
+			t.Errorf("status %d has code %q whose status is %d", status, code, got)
+		}
+	}
+	for code, status := range codeStatus {
+		if status < 400 || status > 599 {
+			t.Errorf("code %q has non error status %d", code, status)
+		}
+	}
+}
+
+// readError decodes the JSON error in rec
+func readError(t *testing.T, rec *httptest.ResponseRecorder) errorJSON {
+	t.Helper()
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type %q, want application/json", got)
+	}
+	var e errorJSON
+	err := json.Unmarshal(rec.Body.Bytes(), &e)
+	if err != nil {
+		t.Fatalf("bad JSON error %q: %v", rec.Body.String(), err)
+	}
+	if e.Status != rec.Code {
+		t.Errorf("status %d in body, %d in response", e.Status, rec.Code)
+	}
+	return e
+}
+
+func TestWriteError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeError(rec, fmt.Errorf("photo gone: %w", httpError(http.StatusNotFound)))
+	e := readError(t, rec)
+	if rec.Code != http.StatusNotFound || e.Code != codePhotoNotFound || e.Error != "photo gone: HTTP Error 404" {
+		t.Errorf("got %d %+v", rec.Code, e)
+	}
+
+	rec = httptest.NewRecorder()
+	writeErrorCode(rec, codeQueueFull, "download queue full")
+	e = readError(t, rec)
+	if rec.Code != http.StatusServiceUnavailable || e.Code != codeQueueFull || e.Error != "download queue full" {
+		t.Errorf("got %d %+v", rec.Code, e)
+	}
+}
+
+func TestHandlerErrorsAreJSON(t *testing.T) {
+	g := newTestGphotos(t, newFakeDownloader(t))
+	setFlag(t, "admin-token", "secret")
+	h := g.handler("")
+	for _, test := range []struct {
+		method string
+		path   string
+		body   string
+		code   string
+	}{
+		{"GET", "/id/x", "", codeBadRequest},
+		{"GET", "/id/" + testPhotoID + "?disposition=sideways", "", codeBadRequest},
+		{"GET", "/id/" + testMissingID, "", codePhotoNotFound},
+		{"POST", "/zip", "{", codeBadRequest},
+		{"POST", "/zip", `{"ids":[]}`, codeBadRequest},
+		{"POST", "/id/" + testPhotoID + "/save", "{}", codeForbidden},
+		{"POST", "/reload", "", codeUnauthorized},
+	} {
+		t.Run(test.method+" "+test.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			h.ServeHTTP(rec, req)
+			e := readError(t, rec)
+			if e.Code != test.code {
+				t.Errorf("code %q, want %q: %s", e.Code, test.code, e.Error)
+			}
+			if want := codeStatus[test.code]; rec.Code != want {
+				t.Errorf("status %d, want %d", rec.Code, want)
+			}
+		})
+	}
+}
+
+func TestMetadataOnlyError(t *testing.T) {
+	setFlag(t, "metadata-only", "true")
+	g := newTestGphotos(t, newFakeDownloader(t))
+	rec := httptest.NewRecorder()
+	g.handler("").ServeHTTP(rec, httptest.NewRequest("GET", "/id/"+testPhotoID, nil))
+	e := readError(t, rec)
+	if rec.Code != http.StatusMethodNotAllowed || e.Code != codeDisabled {
+		t.Errorf("got %d %+v", rec.Code, e)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Sources of the ETag of photos selected with -etag
+const (
+	etagContent  = "content"  // hash of the downloaded photo
+	etagMetadata = "metadata" // the info panel, so no download is needed
+)
+
+// maxETags is the number of photo ETags remembered before the cache
+// is cleared
+const maxETags = 100000
+
+// etagCache remembers the ETag of each photo served so that
+// conditional requests can be answered without downloading again.
+type etagCache struct {
+	mu    sync.Mutex
+	etags map[string]string // photo ID to ETag
+}
+
+// get returns the ETag for photoID if known
+func (c *etagCache) get(photoID string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	etag, ok := c.etags[photoID]
+	return etag, ok
+}
+
+// set remembers the ETag for photoID
+func (c *etagCache) set(photoID, etag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.etags == nil || len(c.etags) >= maxETags {
+		c.etags = map[string]string{}
+	}
+	c.etags[photoID] = etag
+}
+
+// fileSHA256 returns the SHA-256 of the file at path in hex
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sumETag returns a strong ETag made from the hex SHA-256 sum of a
+// file
+func sumETag(sum string) string {
+	return `"` + sum[:32] + `"`
+}
+
+// metaETag returns a weak ETag for frame of the photo with meta, made
+// from the filename, size and dimensions in the info panel.
+//
+// It is weak as the same metadata doesn't promise the same bytes.
+func metaETag(meta *PhotoMeta, frame int) (string, error) {
+	if meta.Filename == "" || meta.Size == "" {
+		return "", errors.New("filename and size not shown in the info panel")
+	}
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%s\n%s\n%s\n%s\n%d", meta.ID, meta.Filename, meta.Size, meta.Dimensions, frame)
+	return `W/"` + hex.EncodeToString(h.Sum(nil))[:32] + `"`, nil
+}
+
+// etagMatch returns true if the If-None-Match header in r matches etag
+// using the weak comparison
+func etagMatch(r *http.Request, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
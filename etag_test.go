@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetaETag(t *testing.T) {
+	meta := &PhotoMeta{ID: testPhotoID, Filename: "IMG_0001.jpg", Size: "2.3 MB", Dimensions: "4032 × 3024", Date: "Jan 5, 2024"}
+	etag, err := metaETag(meta, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(etag, `W/"`) || !strings.HasSuffix(etag, `"`) {
+		t.Errorf("ETag %q isn't weak", etag)
+	}
+
+	// The same metadata gives the same ETag, other fields don't
+	// change it, and a new size or another frame does
+	same := *meta
+	same.Date = "5 Jan 2024"
+	same.Description = "edited"
+	if got, _ := metaETag(&same, 0); got != etag {
+		t.Errorf("ETag changed to %q with the date and description", got)
+	}
+	bigger := *meta
+	bigger.Size = "2.4 MB"
+	if got, _ := metaETag(&bigger, 0); got == etag {
+		t.Error("ETag unchanged with a new size")
+	}
+	if got, _ := metaETag(meta, 1); got == etag {
+		t.Error("ETag the same for another frame")
+	}
+
+	// Without the info panel fields there is no ETag
+	_, err = metaETag(&PhotoMeta{ID: testPhotoID}, 0)
+	if err == nil {
+		t.Error("no error without the metadata")
+	}
+}
+
+func TestETagMatch(t *testing.T) {
+	for _, test := range []struct {
+		etag        string
+		ifNoneMatch string
+		want        bool
+	}{
+		{`"abc"`, `"abc"`, true},
+		{`"abc"`, `W/"abc"`, true},
+		{`W/"abc"`, `W/"abc"`, true},
+		{`W/"abc"`, `"abc"`, true},
+		{`W/"abc"`, `"x", W/"abc"`, true},
+		{`W/"abc"`, `*`, true},
+		{`W/"abc"`, `"abd"`, false},
+		{`W/"abc"`, ``, false},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("If-None-Match", test.ifNoneMatch)
+		if got := etagMatch(r, test.etag); got != test.want {
+			t.Errorf("etagMatch(%q, %q) = %v, want %v", test.ifNoneMatch, test.etag, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// Stages of a download reported in progress events
+const (
+	stageQueued      = "queued"      // waiting for other downloads to finish
+	stageNavigating  = "navigating"  // opening the photo page
+	stageLoaded      = "loaded"      // the photo page has loaded
+	stageDownloading = "downloading" // the browser has started the download
+	stageProgress    = "progress"    // bytes transferred so far
+	stageComplete    = "complete"    // the download finished
+	stageError       = "error"       // the download failed
+)
+
+// progress is a progress event for a download
+type progress struct {
+	Stage    string  `json:"stage"`
+	Filename string  `json:"filename,omitempty"`
+	Received float64 `json:"received,omitempty"`
+	Total    float64 `json:"total,omitempty"`
+	Size     int64   `json:"size,omitempty"`
+	Status   int     `json:"status,omitempty"`
+	Code     string  `json:"code,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// writeEvent writes p as a Server-Sent Event
+func writeEvent(w http.ResponseWriter, flusher http.Flusher, p progress) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		slog.Error("Failed to encode event", "err", err)
+		return
+	}
+	_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", p.Stage, data)
+	flusher.Flush()
+}
+
+// Download a photo ID streaming the progress as Server-Sent Events.
+//
+// The stream ends with a complete or error event. The downloaded
+// file is not served and is removed afterwards.
+func (g *Gphotos) getEvents(w http.ResponseWriter, r *http.Request) {
+	photoID := r.PathValue("photoID")
+	slog := slog.With("id", photoID)
+	slog.Info("got photo events request")
+	if !g.checkPhotoID(w, photoID) || !g.checkShare(w, r, photoID) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeErrorCode(w, codeInternal, "streaming not supported")
+		return
+	}
+
+	type result struct {
+		path string
+		err  error
+	}
+	events := make(chan progress, 64)
+	done := make(chan result, 1)
+	go func() {
+		path, err := g.downloader.DownloadWithProgress(photoID, func(p progress) {
+			// Drop events rather than blocking the download
+			select {
+			case events <- p:
+			default:
+			}
+		})
+		done <- result{path: path, err: err}
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case p := <-events:
+			writeEvent(w, flusher, p)
+		case res := <-done:
+			// Send the events which arrived before the result
+			for len(events) > 0 {
+				writeEvent(w, flusher, <-events)
+			}
+			if res.err != nil {
+				slog.Error("Download image failed", "err", res.err)
+				writeEvent(w, flusher, progress{Stage: stageError, Status: errorStatus(res.err), Code: errorCode(res.err), Error: res.err.Error()})
+				return
+			}
+			p := progress{Stage: stageComplete}
+			fi, err := os.Stat(res.path)
+			if err == nil {
+				p.Size = fi.Size()
+			}
+			writeEvent(w, flusher, p)
+			removeDownload(slog, photoID, res.path)
+			return
+		case <-r.Context().Done():
+			slog.Debug("Events client went away - download continues")
+			go func() {
+				res := <-done
+				if res.err == nil {
+					removeDownload(slog, photoID, res.path)
+				}
+			}()
+			return
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+)
+
+// Exit codes for the ways gphotosdl can fail to start, so supervisors
+// and scripts can tell them apart. These are part of the interface so
+// don't change them.
+const (
+	exitFailure          = 1 // any other failure
+	exitConfig           = 2 // bad flags or config directory
+	exitBrowser          = 3 // the browser failed to launch or connect
+	exitNotAuthenticated = 4 // the browser isn't logged in - run with -login
+	exitServer           = 5 // the web server couldn't listen
+)
+
+// exitError is an error with the exit code to use if it stops
+// gphotosdl starting
+type exitError struct {
+	code int
+	err  error
+}
+
+// Error returns the message of the wrapped error
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the wrapped error
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// withExit returns err with the exit code given
+func withExit(code int, err error) error {
+	return &exitError{code: code, err: err}
+}
+
+// exitCode returns the exit code for the startup error err
+func exitCode(err error) int {
+	var ee *exitError
+	if errors.As(err, &ee) {
+		return ee.code
+	}
+	if errorCode(err) == codeNotAuthenticated {
+		return exitNotAuthenticated
+	}
+	return exitFailure
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stringList is a flag.Value which may be repeated
+type stringList []string
+
+// String returns the values joined with commas
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+// Set adds a value
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// splitList splits the comma separated values in values, eg from the
+// environment, dropping empty ones
+func splitList(values []string) stringList {
+	var list stringList
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+	}
+	return list
+}
+
+// sizeSuffixes are the multipliers for the suffixes sizeValue accepts
+var sizeSuffixes = map[byte]int64{
+	'K': 1 << 10,
+	'M': 1 << 20,
+	'G': 1 << 30,
+	'T': 1 << 40,
+}
+
+// sizeValue is a flag.Value for a size in bytes with an optional
+// binary suffix, eg 100M or 2G
+type sizeValue int64
+
+// String returns the size in bytes
+func (s *sizeValue) String() string {
+	return strconv.FormatInt(int64(*s), 10)
+}
+
+// Set parses a size
+func (s *sizeValue) Set(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+	multiplier := int64(1)
+	if value != "" {
+		if m, ok := sizeSuffixes[value[len(value)-1]]; ok {
+			multiplier = m
+			value = value[:len(value)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q", value)
+	}
+	*s = sizeValue(n * float64(multiplier))
+	return nil
+}
+
+// durationRange is a flag.Value for a range of durations, eg
+// 200ms-1s, or a single duration for a fixed one
+type durationRange struct {
+	min, max time.Duration
+}
+
+// String returns the range as min-max
+func (d *durationRange) String() string {
+	if d.min == 0 && d.max == 0 {
+		return ""
+	}
+	return d.min.String() + "-" + d.max.String()
+}
+
+// Set parses a range
+func (d *durationRange) Set(value string) error {
+	lo, hi, isRange := strings.Cut(strings.TrimSpace(value), "-")
+	if !isRange {
+		hi = lo
+	}
+	minDuration, err := time.ParseDuration(strings.TrimSpace(lo))
+	if err != nil {
+		return fmt.Errorf("invalid duration range %q: %w", value, err)
+	}
+	maxDuration, err := time.ParseDuration(strings.TrimSpace(hi))
+	if err != nil {
+		return fmt.Errorf("invalid duration range %q: %w", value, err)
+	}
+	if minDuration < 0 || maxDuration < minDuration {
+		return fmt.Errorf("invalid duration range %q", value)
+	}
+	d.min, d.max = minDuration, maxDuration
+	return nil
+}
+
+// random returns a random duration in the range
+func (d *durationRange) random() time.Duration {
+	if d.max <= d.min {
+		return d.min
+	}
+	return d.min + rand.N(d.max-d.min+1)
+}
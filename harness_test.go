@@ -0,0 +1,384 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Tests which drive a real browser only run if one is given with the
+// -browser flag, eg
+//
+//	go test -v -args -browser /usr/bin/chromium
+//
+// The rest use a fakeDownloader in place of the browser.
+
+// testPhotoID is a photo which exists on the fakeGoogle site
+const testPhotoID = "AF1QipTestPhoto0000000001"
+
+// testMissingID is a well formed photo ID which doesn't exist
+const testMissingID = "AF1QipMissingPhoto0000001"
+
+// testPhoto is the contents of testPhotoID
+var testPhoto = []byte("\xff\xd8\xff\xe0 not really a JPEG but it starts like one")
+
+// fakeGoogle is a mock of the Google Photos pages gphotosdl uses:
+//
+//   - / is the library, which shows the browser is logged in
+//   - /photo/{id} shows the photo, starts its download on Shift-D and
+//     toggles the info panel on i
+//   - /download/{id} is the download itself
+//   - /image/{id} is the image shown on the photo page
+//   - /album/{id} is an album of the photos in albums
+//
+// Unknown photos get a 404 error page like Google Photos gives.
+type fakeGoogle struct {
+	*httptest.Server
+	mu        sync.Mutex
+	photos    map[string][]byte   // photo ID to contents
+	albums    map[string][]string // album ID to the photo IDs in it
+	downloads atomic.Int64        // downloads served
+	hideSize  atomic.Bool         // leave the file size out of the info panel
+}
+
+// photoPageHTML is the page for a photo. The image URL mentions
+// googleusercontent.com so waitPhotoReady finds it and like Google
+// Photos the image is hidden until it has loaded.
+const photoPageHTML = `<!DOCTYPE html>
+<html><head><title>Photo - Google Photos</title></head>
+<body>
+<img src="/image/%[1]s?googleusercontent.com" style="visibility: hidden" onload="this.style.visibility = 'visible'">
+<script>
+document.addEventListener("keydown", (e) => {
+	if (e.shiftKey && e.key.toUpperCase() === "D") {
+		const a = document.createElement("a");
+		a.href = "/download/%[1]s";
+		a.download = "";
+		document.body.appendChild(a);
+		a.click();
+	} else if (e.key === "i") {
+		const info = document.getElementById("info");
+		if (info) {
+			info.remove();
+			return;
+		}
+		const div = document.createElement("div");
+		div.id = "info";
+		div.innerHTML = %[2]q;
+		document.body.appendChild(div);
+	}
+});
+</script>
+</body></html>`
+
+// testPNG is a 1x1 PNG for the image on the photo page
+var testPNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00\x1f\x15\xc4\x89\x00\x00\x00\rIDATx\x9cc\xf8\xff\xff?\x00\x05\xfe\x02\xfe\xa7\x35\x81\x84\x00\x00\x00\x00IEND\xaeB`\x82")
+
+// newFakeGoogle starts a fakeGoogle with testPhotoID in it
+func newFakeGoogle(t *testing.T) *fakeGoogle {
+	t.Helper()
+	f := &fakeGoogle{
+		photos: map[string][]byte{testPhotoID: testPhoto},
+		albums: map[string][]string{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `<!DOCTYPE html><html><head><title>Photos - Google Photos</title></head><body><p>Library</p></body></html>`)
+	})
+	mux.HandleFunc("GET /photo/{photoID}", func(w http.ResponseWriter, r *http.Request) {
+		photoID := r.PathValue("photoID")
+		data, ok := f.photo(photoID)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `<!DOCTYPE html><html><body><p>Photo not found</p></body></html>`)
+			return
+		}
+		info := `<div aria-label="Filename: IMG_` + photoID[len(photoID)-4:] + `.jpg"></div>`
+		if !f.hideSize.Load() {
+			info += fmt.Sprintf(`<div aria-label="File size: %d B"></div>`, len(data))
+		}
+		_, _ = fmt.Fprintf(w, photoPageHTML, photoID, info)
+	})
+	mux.HandleFunc("GET /album/{albumID}", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		photoIDs, ok := f.albums[r.PathValue("albumID")]
+		f.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `<!DOCTYPE html><html><body><p>Album not found</p></body></html>`)
+			return
+		}
+		_, _ = io.WriteString(w, `<!DOCTYPE html><html><head><title>Album - Google Photos</title></head><body>`)
+		for _, photoID := range photoIDs {
+			_, _ = fmt.Fprintf(w, `<a href="./photo/%s" aria-label="Photo - Landscape - Jan 5, 2024, 3:45:12 PM">%s</a><br>`, photoID, photoID)
+		}
+		_, _ = io.WriteString(w, `</body></html>`)
+	})
+	mux.HandleFunc("GET /image/{photoID}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(testPNG)
+	})
+	mux.HandleFunc("GET /download/{photoID}", func(w http.ResponseWriter, r *http.Request) {
+		photoID := r.PathValue("photoID")
+		data, ok := f.photo(photoID)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		f.downloads.Add(1)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Content-Disposition", `attachment; filename="IMG_`+photoID[len(photoID)-4:]+`.jpg"`)
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		_, _ = w.Write(data)
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// photo returns the contents of photoID if it exists
+func (f *fakeGoogle) photo(photoID string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.photos[photoID]
+	return data, ok
+}
+
+// addPhoto adds a photo to the site
+func (f *fakeGoogle) addPhoto(photoID string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.photos[photoID] = data
+}
+
+// addAlbum adds an album of photoIDs to the site
+func (f *fakeGoogle) addAlbum(albumID string, photoIDs []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.albums[albumID] = photoIDs
+}
+
+// setFlag sets the flag called name to value for the duration of the
+// test
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+	f := flag.Lookup(name)
+	if f == nil {
+		t.Fatalf("no flag %q", name)
+	}
+	old := f.Value.String()
+	err := f.Value.Set(value)
+	if err != nil {
+		t.Fatalf("failed to set -%s: %v", name, err)
+	}
+	t.Cleanup(func() {
+		_ = f.Value.Set(old)
+	})
+}
+
+// setTestDirs points the download and config directories at
+// temporary directories for the test
+func setTestDirs(t *testing.T) {
+	t.Helper()
+	oldDownloadDir, oldConfigRoot, oldBrowserConfig := downloadDir, configRoot, browserConfig
+	downloadDir = t.TempDir()
+	configRoot = browserTempDir(t)
+	browserConfig = filepath.Join(configRoot, "browser")
+	t.Cleanup(func() {
+		downloadDir, configRoot, browserConfig = oldDownloadDir, oldConfigRoot, oldBrowserConfig
+	})
+}
+
+// browserTempDir returns a temporary directory for the browser profile
+// removed at the end of the test.
+//
+// Unlike t.TempDir the removal is retried as the browser may still be
+// writing the profile just after it is closed.
+func browserTempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "gphotosdl-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for try := 0; ; try++ {
+			err := os.RemoveAll(dir)
+			if err == nil {
+				return
+			}
+			if try >= 20 {
+				t.Errorf("failed to remove browser profile: %v", err)
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	})
+	return dir
+}
+
+// newTestGphotos returns a Gphotos without a browser which downloads
+// with d
+func newTestGphotos(t *testing.T, d downloader) *Gphotos {
+	t.Helper()
+	setTestDirs(t)
+	g := &Gphotos{
+		done:       make(chan struct{}),
+		stats:      new(stats),
+		history:    new(history),
+		shares:     new(shareCache),
+		downloader: d,
+	}
+	g.stats.reset()
+	g.lastUsed.Store(time.Now().UnixNano())
+	t.Cleanup(func() {
+		close(g.done)
+	})
+	return g
+}
+
+// newTestServer serves the API of g
+func newTestServer(t *testing.T, g *Gphotos) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(g.handler(""))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// startTestBrowser starts a browser logged in to google and returns
+// the Gphotos using it, skipping the test if -browser isn't set.
+func startTestBrowser(t *testing.T, google *fakeGoogle) *Gphotos {
+	t.Helper()
+	setBrowserFlags(t, google)
+	g := newTestGphotos(t, nil)
+	g.downloader = g
+	g.profileDir = browserConfig
+	var err error
+	g.downloadDir, err = makeWorkerDir(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = g.startBrowser()
+	if err != nil {
+		t.Fatalf("failed to start browser: %v", err)
+	}
+	t.Cleanup(func() {
+		g.life.Lock()
+		defer g.life.Unlock()
+		g.closeBrowser()
+	})
+	return g
+}
+
+// setBrowserFlags points the browser at google and finds it, skipping
+// the test if there is no -browser
+func setBrowserFlags(t *testing.T, google *fakeGoogle) {
+	t.Helper()
+	if *browserName == "" {
+		t.Skip("no browser - run with -args -browser PATH to test with one")
+	}
+	for name, value := range map[string]string{
+		"gphotos-url":      google.URL + "/",
+		"photo-url":        google.URL + "/photo/",
+		"login-url":        google.URL + "/login/",
+		"album-url":        google.URL + "/album/",
+		"ready-timeout":    "10s",
+		"download-timeout": "20s",
+		"download-retries": "0",
+	} {
+		setFlag(t, name, value)
+	}
+	var err error
+	browserPath, err = findBrowser()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeDownloader is a downloader which writes the photos it is given
+// to files instead of using a browser
+type fakeDownloader struct {
+	dir    string
+	mu     sync.Mutex
+	photos map[string][]byte // photo ID to contents
+	errs   map[string]error  // photo ID to the error downloading it gives
+	calls  atomic.Int64      // downloads attempted
+}
+
+// check *fakeDownloader satisfies the interface
+var _ downloader = (*fakeDownloader)(nil)
+
+// newFakeDownloader returns a fakeDownloader with testPhotoID in it
+func newFakeDownloader(t *testing.T) *fakeDownloader {
+	return &fakeDownloader{
+		dir:    t.TempDir(),
+		photos: map[string][]byte{testPhotoID: testPhoto},
+		errs:   map[string]error{},
+	}
+}
+
+// Download photoID
+func (d *fakeDownloader) Download(photoID string) (string, error) {
+	return d.DownloadWithProgress(photoID, nil)
+}
+
+// DownloadWithProgress downloads photoID
+func (d *fakeDownloader) DownloadWithProgress(photoID string, report func(progress)) (string, error) {
+	return d.DownloadFrame(photoID, 0, report)
+}
+
+// DownloadFrame downloads photoID, reporting its filename like the
+// browser does. The frame is ignored.
+func (d *fakeDownloader) DownloadFrame(photoID string, frame int, report func(progress)) (string, error) {
+	d.calls.Add(1)
+	d.mu.Lock()
+	data, ok := d.photos[photoID]
+	err := d.errs[photoID]
+	d.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("photo %q not found: %w", photoID, httpError(http.StatusNotFound))
+	}
+	if report != nil {
+		report(progress{Stage: stageDownloading, Filename: "IMG_" + photoID[len(photoID)-4:] + ".jpg"})
+	}
+	f, err := os.CreateTemp(d.dir, photoID+"-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	closeErr := f.Close()
+	return f.Name(), errors.Join(err, closeErr)
+}
+
+// setError makes downloads of photoID fail with err
+func (d *fakeDownloader) setError(photoID string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.errs[photoID] = err
+}
+
+// files returns the names of the files left in the download directory
+func (d *fakeDownloader) files(t *testing.T) []string {
+	t.Helper()
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
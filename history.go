@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// downloadRecord describes a finished download for GET /history and
+// the -webhook-url
+type downloadRecord struct {
+	ID       string    `json:"id"`
+	Status   int       `json:"status"`
+	Size     int64     `json:"size,omitempty"`
+	Duration float64   `json:"duration"` // seconds
+	Filename string    `json:"filename,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// history is a ring buffer of the most recent downloads
+type history struct {
+	mu      sync.Mutex
+	records []downloadRecord // ring buffer of -history-size records
+	next    int              // index to write the next record
+	full    bool             // set when the buffer has wrapped
+}
+
+// add records a download, dropping the oldest if the buffer is full
+func (h *history) add(rec downloadRecord) {
+	if *historySize <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.records == nil {
+		h.records = make([]downloadRecord, *historySize)
+	}
+	h.records[h.next] = rec
+	h.next++
+	if h.next >= len(h.records) {
+		h.next = 0
+		h.full = true
+	}
+}
+
+// list returns the recorded downloads, newest first
+func (h *history) list() []downloadRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := h.next
+	if h.full {
+		n = len(h.records)
+	}
+	out := make([]downloadRecord, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, h.records[(h.next-i+len(h.records))%len(h.records)])
+	}
+	return out
+}
+
+// Serve the recent downloads as JSON, newest first
+func (g *Gphotos) getHistory(w http.ResponseWriter, r *http.Request) {
+	slog.Info("got history request")
+	writeJSON(w, http.StatusOK, g.history.list())
+}
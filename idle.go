@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// wake records that the browser is in use, starting it again if it
+// was shut down by -idle-timeout-browser.
+//
+// Call with mu held.
+func (g *Gphotos) wake() error {
+	g.lastUsed.Store(time.Now().UnixNano())
+	if !g.asleep.Load() {
+		return nil
+	}
+	g.life.Lock()
+	defer g.life.Unlock()
+	slog.Info("Starting idle browser")
+	err := g.startBrowser()
+	if err != nil {
+		return err
+	}
+	err = g.fillTabPool()
+	if err != nil {
+		return err
+	}
+	g.asleep.Store(false)
+	return nil
+}
+
+// watchIdle shuts the browser down when it hasn't been used for
+// -idle-timeout-browser until done is closed.
+func (g *Gphotos) watchIdle(done <-chan struct{}) {
+	ticker := time.NewTicker(max(*browserIdle/10, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		g.sleepIfIdle()
+	}
+}
+
+// sleepIfIdle closes the browser if it has been idle for longer than
+// -idle-timeout-browser
+func (g *Gphotos) sleepIfIdle() {
+	// Wait for any download in progress to finish
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	idle := time.Since(time.Unix(0, g.lastUsed.Load()))
+	if g.asleep.Load() || idle < *browserIdle {
+		return
+	}
+	// Wait for the metadata requests using the browser
+	g.life.Lock()
+	defer g.life.Unlock()
+	slog.Info("Browser idle - shutting it down until the next request", "idle", idle.Round(time.Second))
+	g.asleep.Store(true)
+	g.closeBrowser()
+	g.browserPID = 0
+}
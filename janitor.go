@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// deletions removes the served photos after -delete-delay
+var deletions janitor
+
+// janitor removes files once their time is up
+type janitor struct {
+	mu    sync.Mutex
+	files map[string]time.Time // path to when to remove it
+}
+
+// schedule path for removal after -delete-delay
+func (j *janitor) schedule(path string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.files == nil {
+		j.files = map[string]time.Time{}
+	}
+	j.files[path] = time.Now().Add(*deleteDelay)
+}
+
+// sweep removes the files which are due
+func (j *janitor) sweep() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	for path, due := range j.files {
+		if now.Before(due) {
+			continue
+		}
+		delete(j.files, path)
+		err := os.Remove(path)
+		if err == nil {
+			slog.Debug("Removed downloaded photo", "path", path)
+		} else if !os.IsNotExist(err) {
+			slog.Error("Failed to remove downloaded photo", "path", path, "err", err)
+		}
+	}
+}
+
+// run sweeps the files until done is closed.
+//
+// Files still waiting then go when the download directories are
+// removed on exit.
+func (j *janitor) run(done <-chan struct{}) {
+	ticker := time.NewTicker(min(*deleteDelay, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			j.sweep()
+		}
+	}
+}
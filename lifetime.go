@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Actions for -max-lifetime-action
+const (
+	lifetimeExit    = "exit"    // exit for a supervisor to restart
+	lifetimeRestart = "restart" // restart the browsers in place
+)
+
+// lifetimeTimer fires after -max-lifetime
+type lifetimeTimer struct {
+	timer *time.Timer // nil if -max-lifetime isn't set
+}
+
+// newLifetimeTimer starts a timer for -max-lifetime
+func newLifetimeTimer() lifetimeTimer {
+	if *maxLifetime <= 0 {
+		return lifetimeTimer{}
+	}
+	at := time.Now().Add(*maxLifetime)
+	slog.Info("Scheduled -max-lifetime", "action", *lifetimeAction, "at", at.Format(time.RFC3339))
+	return lifetimeTimer{timer: time.NewTimer(*maxLifetime)}
+}
+
+// C returns the channel the timer fires on which is nil, so never
+// ready, if -max-lifetime isn't set
+func (t lifetimeTimer) C() <-chan time.Time {
+	if t.timer == nil {
+		return nil
+	}
+	return t.timer.C
+}
+
+// restartAll restarts each browser in place for -max-lifetime,
+// waiting for the download in progress on it to finish first
+func (g *Gphotos) restartAll() {
+	slog.Info("Reached -max-lifetime - restarting the browsers", "max_lifetime", *maxLifetime)
+	for _, w := range append([]*Gphotos{g}, g.workers...) {
+		w.mu.Lock()
+		// An idle browser starts fresh on the next request anyway
+		if !w.asleep.Load() {
+			err := w.restartBrowser("reached -max-lifetime")
+			if err != nil {
+				slog.Error("Failed to restart browser", "err", err)
+			}
+		}
+		w.mu.Unlock()
+	}
+	slog.Info("Restarted the browsers for -max-lifetime")
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net"
+	"sync"
+)
+
+// limitListener is a net.Listener which accepts at most n
+// simultaneous connections.
+//
+// Connections beyond the limit wait in the kernel's accept queue
+// until a slot is free.
+type limitListener struct {
+	net.Listener
+	sem       chan struct{}
+	done      chan struct{} // closed by Close
+	closeOnce sync.Once
+}
+
+// newLimitListener returns a listener which accepts at most n
+// simultaneous connections from l. If n is 0 or less l is returned.
+func newLimitListener(l net.Listener, n int) net.Listener {
+	if n <= 0 {
+		return l
+	}
+	return &limitListener{
+		Listener: l,
+		sem:      make(chan struct{}, n),
+		done:     make(chan struct{}),
+	}
+}
+
+// Accept waits for a free slot then for the next connection.
+//
+// It returns net.ErrClosed if the listener is closed while waiting.
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case l.sem <- struct{}{}:
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.sem
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: func() { <-l.sem }}, nil
+}
+
+// Close the listener, stopping any Accept waiting for a slot
+func (l *limitListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return err
+}
+
+// limitConn releases its slot in the limitListener when closed
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+// Close the connection and release its slot
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// freeAddr returns host:port for a port which was free on host
+func freeAddr(t *testing.T, host string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		t.Skipf("can't listen on %s: %v", host, err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+	return addr
+}
+
+// setAddrs sets -addr to list for the duration of the test
+func setAddrs(t *testing.T, list ...string) {
+	old := addrs
+	addrs = list
+	t.Cleanup(func() {
+		addrs = old
+	})
+}
+
+// startTestServers starts the web servers for g shutting them down at
+// the end of the test
+func startTestServers(t *testing.T, g *Gphotos) error {
+	t.Helper()
+	err := g.startServer()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		for _, server := range g.servers {
+			_ = server.Shutdown(ctx)
+		}
+	})
+	return err
+}
+
+func TestStartServerAddrs(t *testing.T) {
+	v4 := freeAddr(t, "127.0.0.1")
+	v6 := freeAddr(t, "::1")
+	g := newTestGphotos(t, newFakeDownloader(t))
+	setAddrs(t, v4, v6)
+	err := startTestServers(t, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range []string{v4, v6} {
+		resp, body := getPhoto(t, "http://"+addr, "/id/"+testPhotoID)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: status %d: %s", addr, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestStartServerDualStack(t *testing.T) {
+	_, port, err := net.SplitHostPort(freeAddr(t, "::1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l, err := net.Listen("tcp", "127.0.0.1:"+port); err != nil {
+		t.Skipf("port %s not free on IPv4: %v", port, err)
+	} else {
+		_ = l.Close()
+	}
+	g := newTestGphotos(t, newFakeDownloader(t))
+	setAddrs(t, ":"+port)
+	err = startTestServers(t, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"127.0.0.1", "::1"} {
+		addr := net.JoinHostPort(host, port)
+		resp, body := getPhoto(t, "http://"+addr, "/")
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: status %d: %s", addr, resp.StatusCode, body)
+		}
+	}
+}
+
+func TestStartServerBindFails(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = busy.Close()
+	}()
+	free := freeAddr(t, "127.0.0.1")
+	g := newTestGphotos(t, newFakeDownloader(t))
+	setAddrs(t, free, busy.Addr().String())
+	err = startTestServers(t, g)
+	if err == nil {
+		t.Fatal("startServer succeeded with an address in use")
+	}
+	if got := exitCode(err); got != exitServer {
+		t.Errorf("exit code %d, want %d: %v", got, exitServer, err)
+	}
+	if len(g.servers) != 0 {
+		t.Errorf("%d servers started, want 0", len(g.servers))
+	}
+	// The address which did bind must have been released
+	l, err := net.Listen("tcp", free)
+	if err != nil {
+		t.Errorf("%s not released: %v", free, err)
+	} else {
+		_ = l.Close()
+	}
+
+	setAddrs(t, "127.0.0.1:not-a-port")
+	err = startTestServers(t, g)
+	if err == nil || exitCode(err) != exitServer {
+		t.Errorf("got %v, want a failure to listen", err)
+	}
+}
+
+// getWithToken fetches path from srv with token as a bearer token
+// returning the status
+func getWithToken(t *testing.T, srv, path, token string) int {
+	t.Helper()
+	req, err := http.NewRequest("GET", srv+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestStartServerAddrTokens(t *testing.T) {
+	open := freeAddr(t, "127.0.0.1")
+	locked := freeAddr(t, "127.0.0.1")
+	g := newTestGphotos(t, newFakeDownloader(t))
+	setAddrs(t, open, locked)
+	setFlag(t, "admin-token", "admin")
+	old := addrTokens
+	addrTokens = map[string]string{locked: "secret"}
+	t.Cleanup(func() {
+		addrTokens = old
+	})
+	err := startTestServers(t, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "/id/" + testPhotoID
+	for _, test := range []struct {
+		addr   string
+		token  string
+		status int
+	}{
+		{open, "", http.StatusOK},
+		{locked, "", http.StatusUnauthorized},
+		{locked, "wrong", http.StatusUnauthorized},
+		{locked, "secret", http.StatusOK},
+		{locked, "admin", http.StatusOK},
+	} {
+		if got := getWithToken(t, "http://"+test.addr, path, test.token); got != test.status {
+			t.Errorf("%s with token %q: status %d, want %d", test.addr, test.token, got, test.status)
+		}
+	}
+}
+
+func TestParseAddrTokens(t *testing.T) {
+	addrs := []string{"localhost:8282", ":8283"}
+	tokens, err := parseAddrTokens([]string{":8283=a=b"}, addrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[":8283"] != "a=b" {
+		t.Errorf("got %q, want :8283 with token a=b", tokens)
+	}
+	for _, values := range [][]string{
+		{"localhost:8282"},
+		{"localhost:8282="},
+		{"localhost:9999=token"},
+		{":8283=a", ":8283=b"},
+	} {
+		_, err := parseAddrTokens(values, addrs)
+		if err == nil {
+			t.Errorf("%q: no error", values)
+		}
+	}
+}
+
+// If New fails after the server has started it stops the server and
+// the browser again
+func TestNewFailsCleansUp(t *testing.T) {
+	google := newFakeGoogle(t)
+	setBrowserFlags(t, google)
+	setTestDirs(t)
+	addr := freeAddr(t, "127.0.0.1")
+	setAddrs(t, addr)
+	setFlag(t, "pprof", "192.0.2.1:6060")
+
+	g, err := New()
+	if err == nil {
+		g.Close()
+		t.Fatal("New succeeded with a bad -pprof address")
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("server still listening: %v", err)
+	}
+	_ = l.Close()
+}
+
+// Closing a listener at its connection limit stops the Accept waiting
+// for a free slot
+func TestLimitListenerClose(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := newLimitListener(inner, 1)
+	defer func() {
+		_ = l.Close()
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		accepted <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-accepted:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("Accept gave %v, want %v", err, net.ErrClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept still waiting after Close")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// maxLogBackups is the number of rotated log files kept
+const maxLogBackups = 3
+
+// rotatingFile is an io.Writer which writes to a log file, rotating
+// it when it grows beyond maxSize bytes.
+//
+// The rotated files are named path.1 (the newest) to path.N.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	size    int64
+	f       *os.File // nil if reopening after a rotation failed
+}
+
+// newRotatingFile opens path for appending, rotating it when it
+// exceeds maxSize. A maxSize of 0 or less disables rotation.
+func newRotatingFile(path string, maxSize int64) (*rotatingFile, error) {
+	r := &rotatingFile{
+		path:    path,
+		maxSize: maxSize,
+	}
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open the log file for append - call with mu held
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	r.f = f
+	r.size = fi.Size()
+	return nil
+}
+
+// rotate the log file shuffling the backups along - call with mu held
+//
+// If the new log file can't be opened r.f is left nil and the next
+// Write tries again.
+func (r *rotatingFile) rotate() error {
+	err := r.f.Close()
+	r.f = nil
+	if err != nil {
+		return err
+	}
+	for i := maxLogBackups - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	err = os.Rename(r.path, r.path+".1")
+	openErr := r.open()
+	if err == nil {
+		err = openErr
+	}
+	return err
+}
+
+// Write writes p to the log file, rotating it first if needed
+func (r *rotatingFile) Write(p []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		err = r.open()
+		if err != nil {
+			return os.Stderr.Write(p)
+		}
+	}
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		err = r.rotate()
+		if err != nil {
+			// Carry on writing to stderr so we don't lose logs
+			return os.Stderr.Write(p)
+		}
+	}
+	n, err = r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close the log file
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	return r.f.Close()
+}
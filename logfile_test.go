@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gphotosdl.log")
+	r, err := newRotatingFile(path, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Repeat("x", 29) + "\n"
+	for i := 0; i < 20; i++ {
+		_, err := r.Write([]byte(line))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 20 lines of 30 bytes fit 3 to a file so there are 7 files of
+	// which the log and maxLogBackups backups are kept
+	for i := 0; i <= maxLogBackups+1; i++ {
+		name := path
+		if i > 0 {
+			name = fmt.Sprintf("%s.%d", path, i)
+		}
+		fi, err := os.Stat(name)
+		if i > maxLogBackups {
+			if err == nil {
+				t.Errorf("%s kept, want at most %d backups", name, maxLogBackups)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := int64(3 * len(line))
+		if i == 0 {
+			want = int64(2 * len(line))
+		}
+		if fi.Size() != want {
+			t.Errorf("%s is %d bytes, want %d", name, fi.Size(), want)
+		}
+	}
+}
+
+// If the log file can't be reopened after a rotation the next write
+// tries again
+func TestRotatingFileReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gphotosdl.log")
+	r, err := newRotatingFile(path, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = r.Close()
+	}()
+	// As left by a failed rotation
+	_ = r.f.Close()
+	r.f = nil
+	err = os.Remove(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.Write([]byte("hello\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello\n" {
+		t.Errorf("log file has %q, want %q", data, "hello\n")
+	}
+}
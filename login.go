@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+)
+
+// Stages of the Google login flow, for logging the progress of -login
+const (
+	stageSignIn         = "sign in"         // entering the account and password
+	stageChallenge      = "two-factor"      // 2FA code, security key or phone prompt
+	stageDeviceApproval = "device approval" // approve the sign in on another device
+	stageConsentPage    = "consent"         // the cookie consent page
+	stageSignedIn       = "signed in"       // on Google Photos
+	stageOther          = "other"           // anything else, eg account recovery
+)
+
+// loginStage works out which stage of the login flow url is
+func loginStage(url string) string {
+	lower := strings.ToLower(url)
+	switch {
+	case strings.HasPrefix(url, consentURL):
+		return stageConsentPage
+	case strings.HasPrefix(url, *gphotosURL):
+		return stageSignedIn
+	case strings.Contains(lower, "speedbump") || strings.Contains(lower, "deviceapproval") || strings.Contains(lower, "/challenge/dp") || strings.Contains(lower, "/challenge/ipp"):
+		return stageDeviceApproval
+	case strings.Contains(lower, "challenge") || strings.Contains(lower, "/signin/v2/sl/") || strings.Contains(lower, "totp"):
+		return stageChallenge
+	case strings.HasPrefix(url, *loginURL):
+		return stageSignIn
+	}
+	return stageOther
+}
+
+// logLoginStage logs the progress of the login flow when it moves to
+// stage
+func logLoginStage(stage string) {
+	if !*login {
+		slog.Debug("Login stage", "stage", stage)
+		return
+	}
+	switch stage {
+	case stageSignIn:
+		slog.Info("Waiting for you to sign in in the browser")
+	case stageChallenge:
+		slog.Info("Waiting for you to complete the two-factor prompt in the browser")
+	case stageDeviceApproval:
+		slog.Info("Waiting for you to approve the sign in on your phone or other device")
+	case stageConsentPage:
+		slog.Info("Accepting the cookie consent page")
+	case stageSignedIn:
+		slog.Info("Reached Google Photos - checking the login is complete")
+	default:
+		slog.Info("Waiting for the login to finish in the browser", "stage", stage)
+	}
+}
@@ -2,6 +2,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -9,49 +10,159 @@ import (
 	"io"
 	"log"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-rod/rod"
-	"github.com/go-rod/rod/lib/input"
 	"github.com/go-rod/rod/lib/launcher"
 	"github.com/go-rod/rod/lib/proto"
 )
 
 const (
-	program       = "gphotosdl"
-	gphotosURL    = "https://photos.google.com/"
-	loginURL      = "https://accounts.google.com/"
-	gphotoURLReal = "https://photos.google.com/photo/"
-	gphotoURL     = "https://photos.google.com/photo/" // This is the base URL for a direct photo link
-	photoID       = "AF1QipNJVLe7d5mOh-b4CzFAob1UW-6EpFd0HnCBT3c6"
+	program = "gphotosdl"
+	// address for the web server if no -addr is given
+	defaultAddr = "localhost:8282"
+	// time to let HTTP requests in progress finish on shutdown
+	shutdownTimeout = 10 * time.Second
+	// time to read HTTP request headers - protects against slow clients
+	readHeaderTimeout = 10 * time.Second
+	photoID           = "AF1QipNJVLe7d5mOh-b4CzFAob1UW-6EpFd0HnCBT3c6"
+)
+
+// Google URLs - these may be overridden for testing or if Google
+// changes them
+var (
+	gphotosURL = flag.String("gphotos-url", "https://photos.google.com/", "base URL of Google Photos")
+	loginURL   = flag.String("login-url", "https://accounts.google.com/", "URL of the Google login page")
+	gphotoURL  = flag.String("photo-url", "https://photos.google.com/photo/", "base URL for a direct photo link")
+	albumURL   = flag.String("album-url", "https://photos.google.com/album/", "base URL for an album")
+	sharedURL  = flag.String("shared-url", "https://photos.google.com/share/", "base URL for a shared album")
+	lockedURL  = flag.String("locked-url", "https://photos.google.com/lockedfolder/", "base URL of the Locked Folder")
 )
 
 // Flags
 var (
-	debug   = flag.Bool("debug", false, "set to see debug messages")
-	login   = flag.Bool("login", false, "set to launch a visible browser for login, then start the server")
-	show    = flag.Bool("show", false, "set to show the browser (not headless)")
-	addr    = flag.String("addr", "localhost:8282", "address for the web server")
-	useJSON = flag.Bool("json", false, "log in JSON format")
+	debug            = flag.Bool("debug", false, "set to see debug messages")
+	login            = flag.Bool("login", false, "set to launch a visible browser for login, then start the server")
+	loginTimeout     = flag.Duration("login-timeout", 30*time.Minute, "time to wait for -login to be completed in the browser, including any two-factor prompts, 0 for no limit")
+	cookiesFile      = flag.String("cookies-file", "", "JSON or Netscape cookies.txt file of Google cookies to log the browser in with instead of -login")
+	skipAuthCheck    = flag.Bool("skip-auth-check", false, "start serving without checking the browser is logged in - for development and testing")
+	show             = flag.Bool("show", false, "set to show the browser (not headless)")
+	headedFallback   = flag.Bool("headless-fallback", false, "if the headless browser fails to start, start a visible one instead when there is a display")
+	useJSON          = flag.Bool("json", false, "log in JSON format")
+	logFile          = flag.String("log-file", "", "file to write logs to instead of stderr")
+	logSource        = flag.Bool("log-source", false, "add the source file and line to log messages")
+	logTimeFormat    = flag.String("log-time-format", "", "Go time layout or one of rfc3339, rfc3339nano, datetime for log timestamps (default RFC3339 in JSON)")
+	logMaxSize       = flag.Int64("log-max-size", 100, "size in MiB at which to rotate the -log-file, 0 to disable")
+	configDir        = flag.String("config-dir", "", "directory for the browser profile and other state (default the user config directory/"+program+")")
+	showVersion      = flag.Bool("version", false, "print the version and exit")
+	browserName      = flag.String("browser", "", "path or name (chrome, chromium, edge, brave, firefox) of the browser to use (default search for one)")
+	browserStart     = flag.Duration("browser-start-timeout", time.Minute, "time to wait for the browser to start and connect, 0 for no limit")
+	autoDownload     = flag.Bool("auto-download-browser", false, "download Chromium into the config directory if no browser is found")
+	browserRevision  = flag.Int("browser-revision", launcher.RevisionDefault, "Chromium revision to download with -auto-download-browser")
+	offline          = flag.Bool("offline", false, "never download a browser - fail if -auto-download-browser would need to")
+	downloadTimeout  = flag.Duration("download-timeout", 5*time.Minute, "time to wait for each download attempt")
+	maxFailures      = flag.Int("max-consecutive-failures", 5, "restart the browser after this many downloads in a row fail, 0 to disable")
+	navigateRetries  = flag.Int("navigate-retries", 3, "number of times to retry opening a page after a transient network error")
+	breakerThreshold = flag.Int("breaker-threshold", 0, "fail downloads fast after this many in a row fail with auth or transient errors, 0 to disable")
+	breakerCooldown  = flag.Duration("breaker-cooldown", 30*time.Second, "how long downloads fail fast for before one is tried again")
+	maxQueue         = flag.Int("max-queue", 0, "maximum number of downloads waiting for the browser before new ones get 503, 0 for unlimited")
+	downloadRetries  = flag.Int("download-retries", 2, "number of times to retry a timed out or incomplete download with a fresh tab")
+	verifySize       = flag.Bool("verify-size", false, "check the downloaded file is the size the browser expected and retry if not")
+	verify           = flag.Bool("verify", false, "check the downloaded file against the size and any hash the server sent and retry if it doesn't match")
+	gridSearch       = flag.Duration("grid-search-timeout", 30*time.Second, "time to search the library grid for a photo whose page bounces back to it, 0 to disable")
+	waitStrategy     = flag.String("wait-strategy", waitLoad, "when a page is ready after navigating: load (the load event), networkidle (no requests for a while after load) or dom (the page stops changing)")
+	readyTimeout     = flag.Duration("ready-timeout", 30*time.Second, "time to wait for the photo to be shown before downloading")
+	browserIdle      = flag.Duration("idle-timeout-browser", 0, "shut the browser down after no requests for this long and start it again on the next one, 0 to keep it running")
+	tabPoolSize      = flag.Int("tab-pool", 0, "number of new browser tabs to keep open ready for downloads, 0 to open each when needed")
+	browsers         = flag.Int("browsers", 1, "number of independent browsers to download with in parallel, each with a copy of the logged in profile")
+	locale           = flag.String("locale", "en-US", "language for the Google Photos UI so the download shortcut and selectors match, empty for the browser default")
+	userAgent        = flag.String("user-agent", "", "user agent for the browser (default the browser's own, without the headless marker)")
+	consentSelector  = flag.String("consent-selector", `button[aria-label="Accept all"], form[action*="consent.google"] button[value="1"]`, "comma separated CSS selectors for the accept button of the cookie consent banner")
+	downloadMethod   = flag.String("download-method", methodKeypress, "how to start the download: keypress (Shift-D) or click (the download item in the more options menu)")
+	menuSelector     = flag.String("menu-selector", `button[aria-label="More options"], div[role="button"][aria-label="More options"]`, "CSS or XPath selector for the more options button used by -download-method click")
+	stackSelector    = flag.String("stack-selector", `[role="listbox"][aria-label*="stack" i] [role="option"], [role="listbox"][aria-label*="burst" i] [role="option"]`, "CSS or XPath selector for the frames of a burst or stack on the photo page")
+	itemSelector     = flag.String("download-selector", `//*[@role="menuitem"][contains(., "Download")]`, "CSS or XPath selector for the download menu item used by -download-method click")
+	hostRules        = flag.String("host-rules", "", "comma separated host resolver rules for the browser, eg \"MAP photos.google.com 127.0.0.1:8443, EXCLUDE localhost\" (default none)")
+	ignoreCertErrs   = flag.Bool("ignore-cert-errors", false, "INSECURE: make the browser accept invalid TLS certificates - only for test servers and trusted proxies")
+	liteRender       = flag.Bool("lite-render", false, "don't load images or autoplay videos in the browser to save CPU and memory")
+	windowWidth      = flag.Int("window-width", 1920, "width of the browser window in pixels")
+	windowHeight     = flag.Int("window-height", 1080, "height of the browser window in pixels")
+	allowlistFile    = flag.String("allowlist-file", "", "file of photo IDs, one per line, which may be requested - reloaded on SIGHUP (default allow all)")
+	casDir           = flag.String("cas-dir", "", "directory to store every photo served in by SHA-256, eg ab/cd/abcd...jpg, so each is only stored once")
+	saveRoot         = flag.String("save-root", "", "directory POST /id/{photoID}/save may write into (default saving disabled)")
+	metaParallel     = flag.Int("metadata-parallel", 4, "number of metadata and thumbnail requests which may use the browser alongside a download, 0 to queue them behind the downloads")
+	webUI            = flag.Bool("ui", false, "add a form to the root page to download a photo by pasting its Google Photos URL")
+	metadataOnly     = flag.Bool("metadata-only", false, "only serve metadata and captions - requests which download photos get 405")
+	maxSearchItems   = flag.Int("max-search-items", 10000, "maximum number of photos GET /search will list for a date range")
+	maxAlbumItems    = flag.Int("max-album-items", 10000, "maximum number of items to list from an album")
+	rateLimit        = flag.Float64("rate-limit", 0, "maximum requests per minute from each client IP, 0 for unlimited")
+	rateBurst        = flag.Int("rate-burst", 5, "number of requests a client IP may make in a burst over -rate-limit")
+	rateLimitExempt  = flag.String("rate-limit-exempt", "", "comma separated list of IPs or CIDRs which bypass -rate-limit")
+	maxLifetime      = flag.Duration("max-lifetime", 0, "exit cleanly, or restart the browsers with -max-lifetime-action restart, after running this long, 0 for no limit")
+	lifetimeAction   = flag.String("max-lifetime-action", lifetimeExit, "what to do at -max-lifetime: exit (for a supervisor to restart) or restart (the browsers in place)")
+	cleanStaleDirs   = flag.Bool("clean-stale", false, "remove download directories left by previous runs which crashed")
+	cleanStaleAge    = flag.Duration("clean-stale-age", 24*time.Hour, "only remove stale download directories older than this")
+	trustedCIDRs     = flag.String("trusted-proxies", "", "comma separated list of IPs or CIDRs of reverse proxies whose X-Forwarded-For and X-Real-IP headers are trusted")
+	debugDir         = flag.String("debug-dir", "", "directory to save a screenshot and the HTML of the page into when a download fails with -debug (default the config directory/debug)")
+	debugKeep        = flag.Int("debug-keep", 20, "number of failed downloads to keep the -debug-dir artifacts for, 0 to keep all")
+	healthDeep       = flag.Bool("health-deep", false, "make /health open Google Photos in a tab to check it is reachable and logged in")
+	healthDeepTTL    = flag.Duration("health-deep-interval", time.Minute, "how long /health reuses the result of the -health-deep check for")
+	deleteDelay      = flag.Duration("delete-delay", 0, "time to keep downloaded photos after serving them before they are deleted, 0 to delete straight away")
+	keepDownloads    = flag.Bool("keep-downloads", false, "keep downloaded photos in the config directory instead of deleting them - for debugging")
+	readTimeout      = flag.Duration("read-timeout", time.Minute, "maximum time to read an HTTP request, 0 for no limit")
+	writeTimeout     = flag.Duration("write-timeout", time.Hour, "maximum time to write an HTTP response including the download, 0 for no limit")
+	idleTimeout      = flag.Duration("idle-timeout", 2*time.Minute, "time to keep idle HTTP keep-alive connections open")
+	maxConnections   = flag.Int("max-connections", 0, "maximum number of simultaneous HTTP connections, 0 for unlimited")
+	adminToken       = flag.String("admin-token", "", "bearer token required for admin actions (default admin actions disabled)")
+	selftestID       = flag.String("selftest-id", photoID, "photo ID GET /selftest downloads")
+	pprofAddr        = flag.String("pprof", "", "localhost address to serve the Go profiler on, eg localhost:6060 (default off)")
+	historySize      = flag.Int("history-size", 100, "number of recent downloads listed by GET /history, 0 to disable")
+	webhookURL       = flag.String("webhook-url", "", "URL to POST a JSON notification to after each download (default none)")
+	compressJSON     = flag.Bool("compress", false, "gzip the JSON and text responses for clients which accept it - photos are never compressed")
+	etagMode         = flag.String("etag", etagContent, "make the ETag of photos from their content, or their metadata so If-None-Match is answered without downloading: content or metadata")
+	corsOrigin       = flag.String("cors-origin", "", "comma separated list of origins allowed by CORS, or * for any (default off)")
 )
 
+// Flags with custom types
+var (
+	addrs            stringList
+	addrTokenFlags   stringList
+	chromeFlags      stringList
+	maxFileSize      sizeValue
+	humanize         durationRange
+	maxBrowserMemory sizeValue
+)
+
+func init() {
+	flag.Var(&addrs, "addr", "address for the web server, may be repeated to listen on several (default "+defaultAddr+")")
+	flag.Var(&addrTokenFlags, "addr-token", "ADDR=TOKEN bearer token required for all requests to the -addr ADDR, may be repeated (default no token)")
+	flag.Var(&maxFileSize, "max-file-size", "abort downloads larger than this size, eg 500M or 2G (default no limit)")
+	flag.Var(&maxBrowserMemory, "max-browser-memory", "restart the browser between downloads when it uses more memory than this, eg 2G - Linux only (default no limit)")
+	flag.Var(&humanize, "humanize", "random pause, eg 500ms-2s, before opening each photo and before the download keypress to look less automated (default no pause)")
+	flag.Var(&chromeFlags, "chrome-flag", "extra browser switch, eg --disable-extensions or --lang=en (may be repeated)")
+}
+
 // Global variables
 var (
-	configRoot    string // top level config dir, typically ~/.config/gphotodl
-	browserConfig string // work directory for browser instance
-	browserPath   string // path to the browser binary
-	downloadDir   string // temporary directory for downloads
-	browserPrefs  string // JSON config for the browser
+	configRoot    string      // top level config dir, typically ~/.config/gphotodl
+	browserConfig string      // work directory for browser instance
+	browserPath   string      // path to the browser binary
+	downloadDir   string      // temporary directory for downloads
+	browserPrefs  string      // JSON config for the browser
+	keepDir       string      // directory for -keep-downloads
+	artifactDir   string      // directory for the -debug failure artifacts
 	version       = "DEV"     // set by goreleaser
 	commit        = "NONE"    // set by goreleaser
 	date          = "UNKNOWN" // set by goreleaser
-	exitSignals   []os.Signal // Signals to exit on (defined in OS-specific files)
 )
 
 // Remove the download directory and contents
@@ -67,52 +178,230 @@ func removeDownloadDirectory() {
 	}
 }
 
+// makeWorkerDir makes the download directory partition for worker n
+func makeWorkerDir(n int) (string, error) {
+	dir := filepath.Join(downloadDir, fmt.Sprintf("worker-%d", n))
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		return "", fmt.Errorf("failed to make download directory partition: %w", err)
+	}
+	return dir, nil
+}
+
+// checkWritable checks a file can be made in dir
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".write-test-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	err = f.Close()
+	removeErr := os.Remove(name)
+	if err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// timeLayout returns the Go time layout for the -log-time-format flag
+func timeLayout(format string) string {
+	switch strings.ToLower(format) {
+	case "rfc3339":
+		return time.RFC3339
+	case "rfc3339nano":
+		return time.RFC3339Nano
+	case "datetime":
+		return time.DateTime
+	}
+	return format
+}
+
 // Set up the global variables from the flags
 func config() (err error) {
 	version := fmt.Sprintf("%s version %s, commit %s, built at %s", program, version, commit, date)
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nEach flag may also be set with an environment variable named %s<FLAG>, eg %s.\n", envPrefix, envName("log-file"))
 		fmt.Fprintf(os.Stderr, "\n%s\n", version)
 	}
-	flag.Parse()
+	err = setFlagsFromEnv()
+	if err != nil {
+		return err
+	}
+	err = parseFlags(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		return err
+	}
+	if *showVersion {
+		fmt.Println(version)
+		os.Exit(0)
+	}
 
 	// Set up the logger
 	level := slog.LevelInfo
 	if *debug {
 		level = slog.LevelDebug
 	}
+	var logOutput io.Writer = os.Stderr
+	if *logFile != "" {
+		logOutput, err = newRotatingFile(*logFile, *logMaxSize*1024*1024)
+		if err != nil {
+			return err
+		}
+		log.SetOutput(logOutput) // the default handler logs via the log package
+	}
+	opts := &slog.HandlerOptions{
+		Level:     level,
+		AddSource: *logSource,
+	}
+	if *logTimeFormat != "" {
+		layout := timeLayout(*logTimeFormat)
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.String(slog.TimeKey, a.Value.Time().Format(layout))
+			}
+			return a
+		}
+	}
 	if *useJSON {
-		logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+		logger := slog.New(slog.NewJSONHandler(logOutput, opts))
+		slog.SetDefault(logger)
+	} else if *logSource || *logTimeFormat != "" {
+		// The Default Handler can't be configured so use a text handler
+		logger := slog.New(slog.NewTextHandler(logOutput, opts))
 		slog.SetDefault(logger)
 	} else {
 		slog.SetLogLoggerLevel(level) // set log level of Default Handler
 	}
 	slog.Debug(version)
 
-	configRoot, err = os.UserConfigDir()
+	// Make sure the base URLs end in / so IDs can be appended
+	for _, u := range []*string{gphotosURL, loginURL, gphotoURL, albumURL, sharedURL, lockedURL} {
+		if !strings.HasSuffix(*u, "/") {
+			*u += "/"
+		}
+	}
+
+	// Allow comma separated addresses, eg from the environment
+	listenAddrs := splitList(addrs)
+	if len(listenAddrs) == 0 {
+		listenAddrs = stringList{defaultAddr}
+	}
+	for _, a := range listenAddrs {
+		_, _, err := net.SplitHostPort(a)
+		if err != nil {
+			return fmt.Errorf("bad -addr %q - use host:port, [ipv6]:port or :port: %w", a, err)
+		}
+	}
+	addrs = listenAddrs
+	addrTokens, err = parseAddrTokens(splitList(addrTokenFlags), addrs)
+	if err != nil {
+		return fmt.Errorf("bad -addr-token: %w", err)
+	}
+
+	switch *waitStrategy {
+	case waitLoad, waitNetworkIdle, waitDOM:
+	default:
+		return fmt.Errorf("bad -wait-strategy %q - expecting %s, %s or %s", *waitStrategy, waitLoad, waitNetworkIdle, waitDOM)
+	}
+	if *downloadMethod != methodKeypress && *downloadMethod != methodClick {
+		return fmt.Errorf("bad -download-method %q - expecting %s or %s", *downloadMethod, methodKeypress, methodClick)
+	}
+	if *locale != "" && !localeRe.MatchString(*locale) {
+		return fmt.Errorf("bad -locale %q - expecting a language tag like en-US", *locale)
+	}
+	if *hostRules != "" {
+		err = checkHostRules(*hostRules)
+		if err != nil {
+			return fmt.Errorf("bad -host-rules: %w", err)
+		}
+	}
+	if *lifetimeAction != lifetimeExit && *lifetimeAction != lifetimeRestart {
+		return fmt.Errorf("bad -max-lifetime-action %q - expecting %s or %s", *lifetimeAction, lifetimeExit, lifetimeRestart)
+	}
+	if *login && *browsers > 1 {
+		// The profile is copied before the browser starts so the
+		// copies wouldn't see the new login
+		slog.Warn("Ignoring -browsers with -login - run again without -login to use them")
+		*browsers = 1
+	}
+	if *login && *skipAuthCheck {
+		slog.Warn("Ignoring -skip-auth-check with -login - the server starts once login is complete")
+		*skipAuthCheck = false
+	}
+
+	trustedProxies, err = parsePrefixes(*trustedCIDRs)
 	if err != nil {
-		return fmt.Errorf("didn't find config directory: %w", err)
+		return fmt.Errorf("bad -trusted-proxies: %w", err)
+	}
+	if *etagMode != etagContent && *etagMode != etagMetadata {
+		return fmt.Errorf("bad -etag %q - expecting %s or %s", *etagMode, etagContent, etagMetadata)
+	}
+
+	if *configDir != "" {
+		configRoot, err = filepath.Abs(*configDir)
+		if err != nil {
+			return fmt.Errorf("bad -config-dir: %w", err)
+		}
+	} else {
+		configRoot, err = os.UserConfigDir()
+		if err != nil {
+			return fmt.Errorf("didn't find config directory - set -config-dir: %w", err)
+		}
+		configRoot = filepath.Join(configRoot, program)
 	}
-	configRoot = filepath.Join(configRoot, program)
 	browserConfig = filepath.Join(configRoot, "browser")
 	err = os.MkdirAll(browserConfig, 0700)
 	if err != nil {
 		return fmt.Errorf("config directory creation: %w", err)
 	}
+	err = checkWritable(configRoot)
+	if err != nil {
+		return fmt.Errorf("config directory not writable: %w", err)
+	}
 	slog.Debug("Configured config", "config_root", configRoot, "browser_config", browserConfig)
 
+	artifactDir = *debugDir
+	if artifactDir == "" {
+		artifactDir = filepath.Join(configRoot, "debug")
+	}
+
+	if *casDir != "" {
+		err = os.MkdirAll(*casDir, 0777)
+		if err == nil {
+			err = checkWritable(*casDir)
+		}
+		if err != nil {
+			return fmt.Errorf("-cas-dir not writable: %w", err)
+		}
+	}
+
+	if *keepDownloads {
+		keepDir = filepath.Join(configRoot, "downloads")
+		slog.Warn("Keeping all downloaded photos - disk usage will grow without limit", "keep_directory", keepDir)
+	}
+
 	downloadDir, err = os.MkdirTemp("", program)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("download directory creation: %w", err)
 	}
 	slog.Debug("Created download directory", "download_directory", downloadDir)
+	err = writePIDFile(downloadDir)
+	if err != nil {
+		return fmt.Errorf("failed to mark download directory: %w", err)
+	}
+	if *cleanStaleDirs {
+		err = cleanStale()
+		if err != nil {
+			return err
+		}
+	}
 
 	// Find the browser
-	var ok bool
-	browserPath, ok = launcher.LookPath()
-	if !ok {
-		return errors.New("browser not found")
+	browserPath, err = findBrowser()
+	if err != nil {
+		return err
 	}
 	slog.Debug("Found browser", "browser_path", browserPath)
 
@@ -129,6 +418,21 @@ func config() (err error) {
 	browserPrefs = string(prefJSON)
 	slog.Debug("made browser preferences", "prefs", browserPrefs)
 
+	// Log the resolved configuration as a single event. Don't add
+	// secrets to this.
+	slog.Info("startup",
+		"version", version,
+		"config_root", configRoot,
+		"browser_config", browserConfig,
+		"browser_path", browserPath,
+		"download_directory", downloadDir,
+		"addr", []string(addrs),
+		"headless", !*show && !*login,
+		"login", *login,
+		"locale", *locale,
+		"cors_origin", *corsOrigin,
+	)
+
 	return nil
 }
 
@@ -151,95 +455,355 @@ func (logger) Println(vs ...any) {
 }
 
 // Gphotos is a single page browser for Google Photos
+//
+// Downloads and other photo requests each use their own tab and never
+// touch page, the authenticated main page, which must only be
+// accessed with mainPage and setMainPage.
 type Gphotos struct {
-	browser *rod.Browser
-	page    *rod.Page
-	mu      sync.Mutex // only one download at once is allowed
+	browser   *rod.Browser
+	mu        sync.Mutex // only one download at once is allowed
+	pageMu    sync.Mutex // protects page
+	page      *rod.Page
+	allow     *allowlist     // photo IDs which may be requested, nil for all
+	limiter   *rateLimiter   // per client rate limiter, nil for none
+	etags     etagCache      // ETags of the photos served
+	shares    *shareCache    // shared albums of the photos requested
+	albums    albumCache     // photo IDs found in the albums listed
+	images    imageCache     // images shown on the photo pages
+	deep      deepHealth     // last -health-deep check
+	tabs      *tabPool       // pre-warmed tabs, nil for none
+	userAgent string         // user agent for the tabs
+	stats     *stats         // download counters
+	history   *history       // recent downloads
+	servers   []*http.Server // the web servers, one per -addr
+	// used by the handlers to download photos - g itself except in tests
+	downloader downloader
+	// circuit breaker in front of downloader, nil if disabled
+	breaker *breaker
+	// downloads in a row which failed, protected by mu
+	consecutiveFailures int
+	// process ID of the browser, protected by mu
+	browserPID int
+	// when the browser was last used in unix nanoseconds
+	lastUsed atomic.Int64
+	// held for reading by the metadata requests while they use the
+	// browser and for writing while it is started or stopped
+	life sync.RWMutex
+	// limits the metadata requests using the browser at once, nil if
+	// they share mu with the downloads
+	metaSem chan struct{}
+	// set while the browser is shut down by -idle-timeout-browser
+	asleep atomic.Bool
+	// set when the pages show the Google account is out of storage
+	storageFull atomic.Bool
+	// photos served and failed by GET /id since start
+	served, failed atomic.Int64
+	// whether the browser is being restarted
+	restart restartState
+	// closed when the Gphotos is closed to stop the background tasks
+	done chan struct{}
+	// partition of the download directory for this browser's
+	// downloads so concurrent downloads never share a directory
+	downloadDir string
+	// user data directory of the browser
+	profileDir string
+	// the other browsers started by -browsers, nil for none
+	workers []*Gphotos
+	// all the -browsers, nil if there is only one
+	pool *browserPool
 }
 
 // New creates a new browser on the gphotos main page to check we are logged in
 func New() (*Gphotos, error) {
-	g := &Gphotos{}
-	err := g.startBrowser()
+	g := &Gphotos{
+		done:       make(chan struct{}),
+		stats:      new(stats),
+		history:    new(history),
+		shares:     new(shareCache),
+		profileDir: browserConfig,
+	}
+	g.downloader = g
+	g.lastUsed.Store(time.Now().UnixNano())
+	if *metaParallel > 0 {
+		g.metaSem = make(chan struct{}, *metaParallel)
+	}
+	g.stats.reset()
+	var err error
+	g.downloadDir, err = makeWorkerDir(0)
+	if err != nil {
+		return nil, err
+	}
+	// Copy the profile for the other browsers before the first one
+	// starts using it
+	err = copyProfiles()
+	if err != nil {
+		return nil, err
+	}
+	g.allow, err = newAllowlist(*allowlistFile)
+	if err != nil {
+		return nil, err
+	}
+	g.limiter, err = newRateLimiter()
+	if err != nil {
+		return nil, err
+	}
+	g.tabs = newTabPool(*tabPoolSize)
+	err = g.startBrowser()
+	if err != nil {
+		return nil, err
+	}
+	// cleanup stops what has been started if a later step fails
+	cleanup := func() {
+		g.shutdownServers(shutdownTimeout)
+		g.closeWorkers()
+		g.closeBrowser()
+	}
+	err = g.fillTabPool()
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	err = g.startWorkers()
 	if err != nil {
+		cleanup()
 		return nil, err
 	}
+	if *breakerThreshold > 0 {
+		g.breaker = newBreaker(g.downloader)
+		g.downloader = g.breaker
+	}
 	err = g.startServer()
 	if err != nil {
+		cleanup()
 		return nil, err
 	}
+	err = startPprof()
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	// Watch each of the -browsers
+	all := append([]*Gphotos{g}, g.workers...)
+	if maxBrowserMemory > 0 {
+		for _, w := range all {
+			go w.watchMemory(g.done)
+		}
+	}
+	if *deleteDelay > 0 {
+		go deletions.run(g.done)
+	}
+	if *browserIdle > 0 {
+		if *login {
+			slog.Warn("Ignoring -idle-timeout-browser with -login")
+		} else {
+			for _, w := range all {
+				go w.watchIdle(g.done)
+			}
+		}
+	}
 	return g, nil
 }
 
-// start the browser off and check it is authenticated
-func (g *Gphotos) startBrowser() error {
-	// The -login flag implies showing the browser for the user to interact with.
-	isHeadless := !*show && !*login
-
+// newLauncher makes the browser launcher from the flags using the
+// profile in profileDir
+func newLauncher(headless bool, profileDir string) *launcher.Launcher {
 	// We use the default profile in our new data directory
 	l := launcher.New().
 		Bin(browserPath).
-		Headless(isHeadless).
-		UserDataDir(browserConfig).
+		Headless(headless).
+		UserDataDir(profileDir).
 		Preferences(browserPrefs).
 		Set("disable-gpu").
 		Set("disable-audio-output").
+		Set("window-size", fmt.Sprintf("%d,%d", *windowWidth, *windowHeight)).
 		Logger(logger{})
+	setChromeFlags(l)
+	return l
+}
+
+// launchBrowser starts the browser returning its launcher and control
+// URL.
+//
+// If the headless browser fails to start and -headless-fallback is
+// set it tries again with a visible one if there is a display.
+func launchBrowser(ctx context.Context, profileDir string) (*launcher.Launcher, string, error) {
+	// The -login flag implies showing the browser for the user to interact with.
+	isHeadless := !*show && !*login
 
+	l := newLauncher(isHeadless, profileDir).Context(ctx)
 	url, err := l.Launch()
+	if err == nil {
+		return l, url, nil
+	}
+	// Don't leave a browser which is still starting behind
+	l.Kill()
+	if !isHeadless || !*headedFallback || !hasDisplay() {
+		return nil, "", fmt.Errorf("browser launch: %w", err)
+	}
+	slog.Warn("Headless browser failed to start - trying a visible one", "err", err)
+	l = newLauncher(false, profileDir).Context(ctx)
+	url, err = l.Launch()
 	if err != nil {
-		return fmt.Errorf("browser launch: %w", err)
+		l.Kill()
+		return nil, "", fmt.Errorf("browser launch: %w", err)
 	}
+	return l, url, nil
+}
+
+// start the browser off and check it is authenticated
+func (g *Gphotos) startBrowser() error {
+	start := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	if *browserStart > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *browserStart)
+	}
+	defer cancel()
+	startTimeout := func() error {
+		return withExit(exitBrowser, fmt.Errorf("browser didn't start within -browser-start-timeout %v - check it runs here with -debug or -show", *browserStart))
+	}
+
+	l, url, err := launchBrowser(ctx, g.profileDir)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return startTimeout()
+	}
+	if err != nil {
+		return withExit(exitBrowser, err)
+	}
+	g.browserPID = l.PID()
 
 	g.browser = rod.New().
 		ControlURL(url).
 		NoDefaultDevice().
 		Trace(true).
-		SlowMotion(100*time.Millisecond).
+		SlowMotion(100 * time.Millisecond).
 		Logger(logger{})
 
-	err = g.browser.Connect()
+	// Connect keeps using the browser's context for the connection so
+	// bound it with a timer instead
+	connected := make(chan error, 1)
+	go func() {
+		connected <- g.browser.Connect()
+	}()
+	select {
+	case err = <-connected:
+	case <-ctx.Done():
+		l.Kill()
+		return startTimeout()
+	}
 	if err != nil {
-		return fmt.Errorf("failed to connect to browser: %w", err)
+		return withExit(exitBrowser, fmt.Errorf("failed to connect to browser: %w", err))
+	}
+	slog.Debug("Browser started", "elapsed", time.Since(start))
+	err = g.checkBrowser()
+	if err != nil {
+		l.Kill()
+		return err
 	}
 
 	// If -login is passed, start at the login URL. Otherwise, go to photos.
-	startURL := gphotosURL
+	startURL := *gphotosURL
 	if *login {
-		startURL = loginURL
+		startURL = *loginURL
+	}
+
+	err = g.setUserAgent()
+	if err != nil {
+		return err
+	}
+	err = g.setDownloadBehavior()
+	if err != nil {
+		if !isFirefox(browserPath) {
+			return err
+		}
+		slog.Warn("Firefox can't set the download behavior - downloads may prompt", "err", err)
+	}
+
+	page, err := g.browser.Page(proto.TargetCreateTarget{})
+	if err != nil {
+		return fmt.Errorf("couldn't open initial tab: %w", err)
+	}
+	err = g.setupTab(page)
+	if err != nil {
+		return err
+	}
+	g.setMainPage(page)
+
+	if *cookiesFile != "" {
+		cookies, err := loadCookies(*cookiesFile)
+		if err != nil {
+			return err
+		}
+		err = proto.NetworkSetCookies{Cookies: cookies}.Call(page)
+		if err != nil {
+			return fmt.Errorf("failed to set cookies from -cookies-file: %w", err)
+		}
+		slog.Info("Loaded cookies", "cookies", len(cookies), "file", *cookiesFile)
 	}
 
-	g.page, err = g.browser.Page(proto.TargetCreateTarget{URL: startURL})
+	err = navigate(page, slog.Default(), startURL)
 	if err != nil {
 		return fmt.Errorf("couldn't open initial URL: %w", err)
 	}
 
-	err = g.page.WaitLoad()
+	err = waitPage(page)
 	if err != nil {
 		return fmt.Errorf("initial page load: %w", err)
 	}
 
+	if *skipAuthCheck {
+		slog.Warn("NOT CHECKING THE BROWSER IS LOGGED IN because of -skip-auth-check - downloads will fail if it isn't")
+		return nil
+	}
+
 	authenticated := false
 	if *login {
 		slog.Info("A browser window is open. Please log in to your Google account. The server will start automatically once login is complete.")
+		slog.Info("To download from the Locked Folder, open it in the browser and unlock it after logging in.")
 	}
 
-	// Loop indefinitely if login flag is set (waiting for user), otherwise try for 60 seconds.
-	for try := 0; *login || try < 60; try++ {
+	// With -login wait for the user for up to -login-timeout,
+	// otherwise try for 60 seconds.
+	timeout := 60 * time.Second
+	if *login {
+		timeout = *loginTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	stage := ""
+	signedInPolls := 0 // polls in a row on Google Photos
+loop:
+	for try := 0; (*login && timeout <= 0) || time.Now().Before(deadline); try++ {
 		time.Sleep(1 * time.Second)
-		info, err := g.page.Info()
+		info, err := page.Info()
 		if err != nil {
 			slog.Warn("Could not get page info, retrying...", "err", err)
 			continue
 		}
 		slog.Debug("Current URL", "url", info.URL)
 
-		// We are authenticated if we land on the main photos page.
-		if strings.HasPrefix(info.URL, gphotosURL) {
-			authenticated = true
-			slog.Info("Authentication successful.")
-			break
+		newStage := loginStage(info.URL)
+		if newStage != stage {
+			stage = newStage
+			logLoginStage(stage)
 		}
+		switch stage {
+		case stageConsentPage:
+			_, err = dismissConsent(page, slog.Default())
+			if err != nil {
+				slog.Warn("Could not dismiss consent page, retrying...", "err", err)
+			}
+		case stageSignedIn:
+			// We are authenticated if we stay on the main photos
+			// page - the login flow can pass through it on the way
+			// to another prompt
+			signedInPolls++
+			if signedInPolls >= 2 {
+				authenticated = true
+				slog.Info("Authentication successful.")
+				break loop
+			}
+			continue
+		}
+		signedInPolls = 0
 
 		// Show this message only on the first try in non-login mode.
 		if try == 0 && !*login {
@@ -247,32 +811,135 @@ func (g *Gphotos) startBrowser() error {
 		}
 	}
 
+	if !authenticated && *login {
+		return withCode(codeNotAuthenticated, fmt.Errorf("login not completed within -login-timeout %v, last at the %s stage", timeout, stage))
+	}
+	if !authenticated && *cookiesFile != "" {
+		return withCode(codeNotAuthenticated, fmt.Errorf("browser is not logged in with the cookies from -cookies-file %q - they may have expired, export them again", *cookiesFile))
+	}
 	if !authenticated {
-		return errors.New("browser is not logged in - rerun with the -login flag")
+		return withCode(codeNotAuthenticated, errors.New("browser is not logged in - rerun with the -login flag"))
 	}
 	return nil
 }
 
+// mainPage returns the authenticated main page
+func (g *Gphotos) mainPage() *rod.Page {
+	g.pageMu.Lock()
+	defer g.pageMu.Unlock()
+	return g.page
+}
+
+// setMainPage sets the authenticated main page
+func (g *Gphotos) setMainPage(page *rod.Page) {
+	g.pageMu.Lock()
+	defer g.pageMu.Unlock()
+	g.page = page
+}
+
+// handler returns the HTTP handler serving the API, needing token as a
+// bearer token if set
+func (g *Gphotos) handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /", g.getRoot)
+	mux.HandleFunc("GET /favicon.ico", getFavicon)
+	mux.Handle("GET /static/", http.FileServer(http.FS(staticFS)))
+	// Rate limit the endpoints which use the browser and turn them
+	// away while it restarts
+	limit := func(next http.HandlerFunc) http.HandlerFunc {
+		return g.limiter.wrap(g.wrapRestart(next))
+	}
+	// Turn away the endpoints which download photos with -metadata-only
+	download := func(next http.HandlerFunc) http.HandlerFunc {
+		if *metadataOnly {
+			return metadataOnlyError
+		}
+		return limit(next)
+	}
+	mux.HandleFunc("GET /id/{photoID}", download(g.getID))
+	mux.HandleFunc("GET /id/{photoID}/meta", limit(compress(g.getMeta)))
+	mux.HandleFunc("GET /id/{photoID}/caption", limit(compress(g.getCaption)))
+	mux.HandleFunc("GET /id/{photoID}/events", download(g.getEvents))
+	mux.HandleFunc("POST /id/{photoID}/save", download(g.postSave))
+	mux.HandleFunc("GET /id/{photoID}/thumb", limit(g.getThumb))
+	mux.HandleFunc("GET /id/{photoID}/srcset", limit(compress(g.getSrcset)))
+	mux.HandleFunc("GET /id/{photoID}/frames", limit(compress(g.getFrames)))
+	mux.HandleFunc("GET /id/{photoID}/screenshot", limit(g.getScreenshot))
+	mux.HandleFunc("GET /album/{albumID}", limit(compress(g.getAlbum)))
+	mux.HandleFunc("GET /search", limit(compress(g.getSearch)))
+	mux.HandleFunc("POST /zip", download(g.postZip))
+	mux.HandleFunc("GET /health", compress(g.getHealth))
+	mux.HandleFunc("GET /stats", compress(g.getStats))
+	mux.HandleFunc("GET /history", compress(g.getHistory))
+	mux.HandleFunc("GET /selftest", download(g.getSelftest))
+	mux.HandleFunc("GET /debug/tabs", compress(g.getDebugTabs))
+	mux.HandleFunc("POST /reload", g.wrapRestart(g.postReload))
+	var h http.Handler = mux
+	if token != "" {
+		h = requireToken(token, mux)
+	}
+	return accessLog(cors(h))
+}
+
 // start the web server off
 func (g *Gphotos) startServer() error {
-	slog.Info("Starting web server", "address", *addr)
-	http.HandleFunc("GET /", g.getRoot)
-	http.HandleFunc("GET /id/{photoID}", g.getID)
-	go func() {
-		err := http.ListenAndServe(*addr, nil)
-		if errors.Is(err, http.ErrServerClosed) {
-			slog.Debug("web server closed")
-		} else if err != nil {
-			slog.Error("Error starting web server", "err", err)
-			os.Exit(1)
+	slog.Info("Starting web server", "address", []string(addrs))
+	// Listen on all the addresses before serving so a bad one stops
+	// the startup
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return withExit(exitServer, fmt.Errorf("failed to listen on %q: %w", addr, err))
 		}
-	}()
+		listeners = append(listeners, newLimitListener(listener, *maxConnections))
+	}
+	for i, listener := range listeners {
+		server := &http.Server{
+			Handler:           g.handler(addrTokens[addrs[i]]),
+			ReadHeaderTimeout: readHeaderTimeout,
+			ReadTimeout:       *readTimeout,
+			WriteTimeout:      *writeTimeout,
+			IdleTimeout:       *idleTimeout,
+		}
+		g.servers = append(g.servers, server)
+		go func() {
+			err := server.Serve(listener)
+			if errors.Is(err, http.ErrServerClosed) {
+				slog.Debug("web server closed", "address", listener.Addr())
+			} else if err != nil {
+				slog.Error("Error starting web server", "address", listener.Addr(), "err", err)
+				os.Exit(exitServer)
+			}
+		}()
+	}
 	return nil
 }
 
+// metadataOnlyError rejects requests to download photos when
+// -metadata-only is set
+func metadataOnlyError(w http.ResponseWriter, r *http.Request) {
+	slog.Warn("Rejected download in metadata only mode", "path", r.URL.Path)
+	writeErrorCode(w, codeDisabled, "downloads are disabled by -metadata-only")
+}
+
 // Serve the root page
 func (g *Gphotos) getRoot(w http.ResponseWriter, r *http.Request) {
 	slog.Info("got / request")
+	form := ""
+	if *webUI && !*metadataOnly {
+		form = `
+  <form id="download-form">
+    <label for="photo">Google Photos URL or photo ID</label><br>
+    <input type="text" id="photo" name="photo" placeholder="https://photos.google.com/photo/..." required>
+    <button type="submit">Download</button>
+  </form>
+  <p id="download-status" role="status"></p>
+  <script src="/static/ui.js"></script>`
+	}
 	_, _ = io.WriteString(w, `
 <!DOCTYPE html>
 <html lang="en">
@@ -280,10 +947,13 @@ func (g *Gphotos) getRoot(w http.ResponseWriter, r *http.Request) {
   <meta charset="utf-8">
   <meta name="viewport" content="width=device-width, initial-scale=1">
   <title>`+program+`</title>
+  <link rel="icon" href="/favicon.ico">
+  <link rel="stylesheet" href="/static/style.css">
 </head>
 <body>
   <h1>`+program+`</h1>
   <p>`+program+` is used to download full resolution Google Photos in combination with rclone.</p>
+  <p>Served `+strconv.FormatInt(g.served.Load(), 10)+` photos, `+strconv.FormatInt(g.failed.Load(), 10)+` failed since start.</p>`+form+`
 </body>
 </html>`)
 }
@@ -291,31 +961,252 @@ func (g *Gphotos) getRoot(w http.ResponseWriter, r *http.Request) {
 // Serve a photo ID
 func (g *Gphotos) getID(w http.ResponseWriter, r *http.Request) {
 	photoID := r.PathValue("photoID")
-	slog.Info("got photo request", "id", photoID)
-	path, err := g.Download(photoID)
-	if err != nil {
-		slog.Error("Download image failed", "id", photoID, "err", err)
-		var h httpError
-		if errors.As(err, &h) {
-			w.WriteHeader(int(h))
+	slog.Info("got photo request", "id", photoID, "client", clientIP(r))
+	if !g.checkPhotoID(w, photoID) || !g.checkShare(w, r, photoID) {
+		return
+	}
+	disposition, ok := checkDisposition(w, r)
+	if !ok {
+		return
+	}
+	frame, ok := checkFrame(w, r)
+	if !ok {
+		return
+	}
+	etagKey := photoID
+	if frame > 0 {
+		etagKey += "#" + strconv.Itoa(frame)
+	}
+
+	// Photos don't change so if the client has the version we served
+	// before there is no need to download it again. With -etag
+	// metadata the version is read from the info panel so this works
+	// for photos not served before too.
+	etag, known := g.etags.get(etagKey)
+	if !known && *etagMode == etagMetadata {
+		meta, err := g.Meta(photoID)
+		if err == nil {
+			etag, err = metaETag(meta, frame)
+		}
+		if err == nil {
+			known = true
+			g.etags.set(etagKey, etag)
 		} else {
-			w.WriteHeader(http.StatusInternalServerError)
+			slog.Warn("Failed to make ETag from metadata - using the content hash", "id", photoID, "err", err)
 		}
+	}
+	if known && etagMatch(r, etag) {
+		slog.Info("Photo not modified", "id", photoID, "etag", etag)
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
-	slog.Info("Downloaded photo", "id", photoID, "path", path)
 
-	// Remove the file after it has been served
+	path, filename, err := g.downloadNamed(photoID, frame)
+	if err != nil {
+		g.failed.Add(1)
+		slog.Error("Download image failed", "id", photoID, "err", err)
+		writeError(w, err)
+		return
+	}
+	slog.Info("Downloaded photo", "id", photoID, "path", path, "filename", filename)
+
+	// Remove the file after it has been served, or store it in
+	// -cas-dir once its hash is known
+	sum, ext := "", ""
 	defer func() {
-		err := os.Remove(path)
-		if err == nil {
-			slog.Debug("Removed downloaded photo", "id", photoID, "path", path)
-		} else {
-			slog.Error("Failed to remove downloaded photo", "id", photoID, "path", path, "err", err)
+		finishDownload(slog.With("id", photoID), photoID, path, sum, ext)
+	}()
+
+	contentType, err := sniffContentType(path)
+	if err == nil {
+		w.Header().Set("Content-Type", contentType)
+	} else {
+		slog.Error("Failed to detect content type", "id", photoID, "err", err)
+	}
+	w.Header().Set("Content-Disposition", contentDisposition(disposition, contentType, filename))
+
+	sum, err = fileSHA256(path)
+	if err == nil {
+		w.Header().Set("X-Content-SHA256", sum)
+		ext = casExt(filename, contentType)
+	} else {
+		slog.Error("Failed to hash photo", "id", photoID, "err", err)
+	}
+	// Keep the ETag from the metadata, otherwise use the hash
+	if !known || *etagMode != etagMetadata {
+		etag = ""
+		if sum != "" {
+			etag = sumETag(sum)
+			g.etags.set(etagKey, etag)
 		}
+	}
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+
+	// The download is complete so serve it with Range support to let
+	// clients with a partial file fetch the remainder. The ETag lets
+	// them check with If-Range that it is the same file.
+	f, err := os.Open(path)
+	if err != nil {
+		g.failed.Add(1)
+		slog.Error("Failed to open downloaded photo", "id", photoID, "err", err)
+		writeError(w, err)
+		return
+	}
+	defer func() {
+		_ = f.Close()
 	}()
+	g.served.Add(1)
+	http.ServeContent(w, r, "", time.Time{}, f)
+}
+
+// removeDownload removes the downloaded photo at path, or moves it
+// into the keep directory if -keep-downloads is set.
+//
+// With -delete-delay the removal is left to the janitor.
+func removeDownload(slog *slog.Logger, photoID, path string) {
+	if *keepDownloads {
+		keepDownload(slog, photoID, path)
+		return
+	}
+	if *deleteDelay > 0 {
+		deletions.schedule(path)
+		slog.Debug("Scheduled removal of downloaded photo", "path", path, "delay", *deleteDelay)
+		return
+	}
+	err := os.Remove(path)
+	if err == nil {
+		slog.Debug("Removed downloaded photo", "path", path)
+	} else {
+		slog.Error("Failed to remove downloaded photo", "path", path, "err", err)
+	}
+}
+
+// keepDownload moves the downloaded photo at path into a directory
+// named by the photo ID and time under the keep directory.
+func keepDownload(slog *slog.Logger, photoID, path string) {
+	dir := filepath.Join(keepDir, photoID+"-"+time.Now().Format("20060102T150405.000"))
+	err := os.MkdirAll(dir, 0700)
+	if err == nil {
+		dest := filepath.Join(dir, filepath.Base(path))
+		err = moveFile(path, dest)
+		if err == nil {
+			slog.Info("Kept downloaded photo", "path", dest)
+			return
+		}
+	}
+	slog.Error("Failed to keep downloaded photo", "path", path, "err", err)
+	_ = os.Remove(path)
+}
+
+// Serve the metadata for a photo ID
+func (g *Gphotos) getMeta(w http.ResponseWriter, r *http.Request) {
+	photoID := r.PathValue("photoID")
+	slog.Info("got photo metadata request", "id", photoID)
+	if !g.checkPhotoID(w, photoID) || !g.checkShare(w, r, photoID) {
+		return
+	}
+	meta, err := g.Meta(photoID)
+	if err != nil {
+		slog.Error("Read metadata failed", "id", photoID, "err", err)
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, meta)
+}
+
+// Serve the caption of a photo ID as plain text, or JSON if
+// requested with ?format=json or the Accept header.
+func (g *Gphotos) getCaption(w http.ResponseWriter, r *http.Request) {
+	photoID := r.PathValue("photoID")
+	slog.Info("got photo caption request", "id", photoID)
+	if !g.checkPhotoID(w, photoID) || !g.checkShare(w, r, photoID) {
+		return
+	}
+	meta, err := g.Meta(photoID)
+	if err != nil {
+		slog.Error("Read caption failed", "id", photoID, "err", err)
+		writeError(w, err)
+		return
+	}
+	if meta.Description == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"id":      photoID,
+			"caption": meta.Description,
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, meta.Description)
+}
+
+// Serve the health of the browser
+func (g *Gphotos) getHealth(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("got health request")
+	if !g.rlockAwake() {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "idle"})
+		return
+	}
+	v, err := g.browser.Timeout(10 * time.Second).Version()
+	url := ""
+	if err == nil {
+		if page := g.mainPage(); page != nil {
+			info, err := page.Timeout(10 * time.Second).Info()
+			if err == nil {
+				url = info.URL
+			}
+		}
+	}
+	g.life.RUnlock()
+	if err != nil {
+		slog.Error("Health check failed", "err", err)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{
+			"status": healthBrowserDown,
+			"error":  err.Error(),
+		})
+		return
+	}
+	health := map[string]string{
+		"status":  healthOK,
+		"browser": v.Product,
+	}
+	if account := g.accountHealth(); account != accountOK {
+		health["account"] = account
+	}
+	if url != "" {
+		health["url"] = url
+	}
+	if *healthDeep {
+		status, url, checked, err := g.deepCheck()
+		health["google"] = status
+		health["google_checked"] = checked.Format(time.RFC3339)
+		if url != "" {
+			health["google_url"] = url
+		}
+		if err != nil {
+			health["status"] = status
+			health["error"] = err.Error()
+			writeJSON(w, http.StatusServiceUnavailable, health)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, health)
+}
 
-	http.ServeFile(w, r, path)
+// writeJSON writes v as a JSON response with the status given
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		slog.Error("Failed to write JSON response", "err", err)
+	}
 }
 
 // httpError wraps an HTTP status code
@@ -325,71 +1216,429 @@ func (h httpError) Error() string {
 	return fmt.Sprintf("HTTP Error %d", h)
 }
 
+// errorStatus returns the HTTP status code to use for err
+func errorStatus(err error) int {
+	var ce *codeError
+	if errors.As(err, &ce) {
+		return codeStatus[ce.code]
+	}
+	var h httpError
+	if errors.As(err, &h) {
+		return int(h)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// errIncompleteDownload is returned when the downloaded file is
+// empty or the wrong size
+var errIncompleteDownload = errors.New("incomplete download")
+
 // Download a photo with the ID given
 // Returns the path to the photo which should be deleted after use
 func (g *Gphotos) Download(photoID string) (string, error) {
+	return g.DownloadWithProgress(photoID, nil)
+}
+
+// DownloadWithProgress downloads a photo with the ID given calling
+// report (if not nil) as the download proceeds.
+//
+// report must not block.
+// Returns the path to the photo which should be deleted after use
+func (g *Gphotos) DownloadWithProgress(photoID string, report func(progress)) (string, error) {
+	return g.DownloadFrame(photoID, 0, report)
+}
+
+// DownloadFrame downloads frame of the burst or stack photoID, or the
+// photo the download key gives if frame is 0, calling report (if not
+// nil) as the download proceeds.
+//
+// report must not block.
+// Returns the path to the photo which should be deleted after use
+func (g *Gphotos) DownloadFrame(photoID string, frame int, report func(progress)) (string, error) {
+	start := time.Now()
+	if report == nil {
+		report = func(progress) {}
+	}
+	// Remember the file name for the history and webhook
+	var filename atomic.Pointer[string]
+	next := report
+	report = func(p progress) {
+		if p.Stage == stageDownloading {
+			filename.Store(&p.Filename)
+		}
+		next(p)
+	}
+	notify := func(path string, err error) {
+		rec := downloadRecord{
+			ID:       photoID,
+			Status:   http.StatusOK,
+			Duration: time.Since(start).Seconds(),
+			Time:     time.Now(),
+		}
+		if name := filename.Load(); name != nil {
+			rec.Filename = *name
+		}
+		if err != nil {
+			rec.Status = errorStatus(err)
+			rec.Error = err.Error()
+		} else if fi, err := os.Stat(path); err == nil {
+			rec.Size = fi.Size()
+		}
+		g.history.add(rec)
+		notifyWebhook(rec)
+	}
+	err := g.stats.reserveQueue()
+	if err != nil {
+		slog.Warn("Download queue full - rejecting download", "id", photoID, "err", err)
+		return "", err
+	}
+	report(progress{Stage: stageQueued})
+
 	// Can only download one picture at once
 	g.mu.Lock()
+	g.stats.releaseQueue()
 	defer g.mu.Unlock()
-	url := gphotoURL + photoID
 
 	slog := slog.With("id", photoID)
-
-	// Create a new blank browser tab
-	slog.Debug("Open new tab")
-	page, err := g.browser.Page(proto.TargetCreateTarget{})
+	err = g.wake()
 	if err != nil {
-		return "", fmt.Errorf("failed to open browser tab for photo %q: %w", photoID, err)
+		g.stats.record(time.Since(start), err)
+		notify("", err)
+		return "", err
 	}
-	defer func() {
-		_ = page.Close()
-	}()
+
+	// Retry timed out attempts with a fresh tab
+	for try := 1; ; try++ {
+		path, err := g.download(slog, photoID, frame, report)
+		if err == nil {
+			g.stats.record(time.Since(start), nil)
+			g.consecutiveFailures = 0
+			notify(path, nil)
+			return path, nil
+		}
+		retry := errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errIncompleteDownload)
+		if !retry || try > *downloadRetries {
+			err = g.accountError(err)
+			g.stats.record(time.Since(start), err)
+			g.checkWedged(err)
+			notify("", err)
+			return "", err
+		}
+		slog.Warn("Download failed - retrying with a fresh tab", "try", try, "retries", *downloadRetries, "err", err)
+	}
+}
+
+// download makes a single attempt at downloading photoID in a new
+// tab, giving up after -download-timeout.
+func (g *Gphotos) download(slog *slog.Logger, photoID string, frame int, report func(progress)) (string, error) {
+	ctx, cancelTimeout := context.WithTimeout(context.Background(), *downloadTimeout)
+	defer cancelTimeout()
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
 
 	// Download waiter
-	wait, err := page.WaitDownload()
+	wait := g.browser.Context(ctx).WaitDownload(g.downloadDir)
+
+	// Report the download progress and abort it if it is too big
+	var totalBytes atomic.Int64 // size the browser expects the download to be
+	tooLarge := fmt.Errorf("photo %q is larger than -max-file-size %d: %w", photoID, int64(maxFileSize), httpError(http.StatusRequestEntityTooLarge))
+	aborted := false
+	waitProgress := g.browser.Context(ctx).EachEvent(func(e *proto.PageDownloadWillBegin) {
+		report(progress{Stage: stageDownloading, Filename: e.SuggestedFilename})
+	}, func(e *proto.PageDownloadProgress) bool {
+		report(progress{Stage: stageProgress, Received: e.ReceivedBytes, Total: e.TotalBytes})
+		totalBytes.Store(int64(e.TotalBytes))
+		if maxSize := float64(maxFileSize); !aborted && maxSize > 0 && (e.TotalBytes > maxSize || e.ReceivedBytes > maxSize) {
+			slog.Warn("Download too large - aborting", "size", int64(max(e.TotalBytes, e.ReceivedBytes)), "max_file_size", int64(maxFileSize))
+			err := proto.BrowserCancelDownload{GUID: e.GUID}.Call(g.browser)
+			if err != nil {
+				slog.Error("Failed to cancel download", "err", err)
+			}
+			aborted = true
+		}
+		if e.State == proto.PageDownloadProgressStateInProgress {
+			return false
+		}
+		if aborted {
+			// The browser has stopped writing the download so
+			// the partial file can be removed
+			removePartialDownload(g.downloadDir, e.GUID)
+			cancel(tooLarge)
+		}
+		return true
+	})
+	go waitProgress()
+
+	err := pause(ctx, slog)
 	if err != nil {
-		return "", fmt.Errorf("failed to set up download waiter: %w", err)
+		return "", err
+	}
+	report(progress{Stage: stageNavigating})
+	page, err := g.openPhoto(ctx, slog, photoID)
+	if err != nil {
+		return "", err
+	}
+	defer g.closeTab(page)
+	report(progress{Stage: stageLoaded})
+
+	// Save what the page looked like if the download can't be started
+	fail := func(err error) (string, error) {
+		saveFailureArtifacts(page, slog, photoID, err)
+		return "", err
 	}
 
-	// Navigate to the photo URL
-	slog.Debug("Navigate to photo URL")
-	err = page.Navigate(url)
+	err = ensurePhotoView(page, slog, photoID)
 	if err != nil {
-		return "", fmt.Errorf("failed to navigate to photo %q: %w", photoID, err)
+		return fail(err)
 	}
 
-	err = page.WaitLoad()
+	// Wait for the photo to be shown so the page is ready for key presses.
+	err = waitPhotoReady(page, slog)
 	if err != nil {
-		return "", fmt.Errorf("gphoto page load: %w", err)
+		return fail(err)
 	}
 
-	// A short delay can help ensure the page is ready for key presses.
-	time.Sleep(time.Second)
+	if frame > 0 {
+		err = selectFrame(page, slog, photoID, frame)
+		if err != nil {
+			return fail(err)
+		}
+	}
 
-	// Shift-D to download
-	err = page.KeyActions().Press(input.ShiftLeft).Type('D').Do()
+	// Turn away photos which are too large before starting the
+	// download. The download progress is still checked in case the
+	// size isn't shown.
+	if maxFileSize > 0 {
+		err = checkFileSize(page, slog, photoID)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = pause(ctx, slog)
 	if err != nil {
-		return "", fmt.Errorf("failed to send download keypress: %w", err)
+		return "", err
+	}
+
+	// Catch the size and hashes of the download for -verify
+	var integrity atomic.Pointer[integrityRef]
+	if *verify {
+		go page.EachEvent(func(e *proto.NetworkResponseReceived) bool {
+			if !isDownloadResponse(e.Response.Headers) {
+				return false
+			}
+			integrity.Store(parseIntegrityRef(e.Response.Headers))
+			return true
+		})()
+	}
+
+	err = startDownload(page, slog)
+	if err != nil {
+		return fail(err)
 	}
 
 	// Wait for download
 	slog.Debug("Wait for download")
 	downloadEvent := wait()
-	path := filepath.Join(downloadDir, downloadEvent.GUID)
+	if err := context.Cause(ctx); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fail(fmt.Errorf("timed out waiting for download of photo %q: %w", photoID, err))
+		}
+		return "", err
+	}
+	err = waitFileComplete(ctx, g.downloadDir, downloadEvent.GUID)
+	if err != nil {
+		return "", fmt.Errorf("download of photo %q didn't complete: %w", photoID, err)
+	}
+	path, err := renameDownload(g.downloadDir, photoID, downloadEvent.GUID, downloadEvent.SuggestedFilename)
+	if err != nil {
+		return "", err
+	}
 
 	// Check file
 	fi, err := os.Stat(path)
 	if err != nil {
 		return "", fmt.Errorf("download failed, file not found: %w", err)
 	}
+	if fi.Size() == 0 {
+		_ = os.Remove(path)
+		return "", fmt.Errorf("download of photo %q is empty: %w", photoID, errIncompleteDownload)
+	}
+	if maxFileSize > 0 && fi.Size() > int64(maxFileSize) {
+		// The download finished before it could be cancelled
+		slog.Warn("Download too large - removing", "size", fi.Size(), "max_file_size", int64(maxFileSize))
+		_ = os.Remove(path)
+		return "", tooLarge
+	}
+	if expected := totalBytes.Load(); (*verifySize || *verify) && expected > 0 && fi.Size() != expected {
+		_ = os.Remove(path)
+		return "", fmt.Errorf("download of photo %q is %d bytes but expected %d: %w", photoID, fi.Size(), expected, errIncompleteDownload)
+	}
+	if *verify {
+		err = verifyIntegrity(slog, path, integrity.Load())
+		if err != nil {
+			_ = os.Remove(path)
+			return "", fmt.Errorf("download of photo %q failed verification: %w", photoID, err)
+		}
+	}
 
 	slog.Debug("Download successful", "size", fi.Size(), "path", path)
 
 	return path, nil
 }
 
-// Close the browser
-func (g *Gphotos) Close() {
+// pause for a random time in the -humanize range or until ctx is done
+func pause(ctx context.Context, slog *slog.Logger) error {
+	d := humanize.random()
+	if d <= 0 {
+		return nil
+	}
+	slog.Debug("Pausing", "duration", d)
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// fileStableInterval is how long the size of a finished download must
+// stay the same for it to be considered complete
+const fileStableInterval = 250 * time.Millisecond
+
+// waitFileComplete waits until the download guid in dir has no
+// .crdownload partial file left and its size has stopped changing, in
+// case the browser reported it finished early.
+func waitFileComplete(ctx context.Context, dir, guid string) error {
+	path := filepath.Join(dir, guid)
+	lastSize := int64(-1)
+	for {
+		_, err := os.Stat(path + ".crdownload")
+		partial := err == nil
+		fi, err := os.Stat(path)
+		if err != nil && !partial {
+			return fmt.Errorf("file not found: %w", err)
+		}
+		if !partial && fi.Size() == lastSize {
+			return nil
+		}
+		if err == nil {
+			lastSize = fi.Size()
+		}
+		timer := time.NewTimer(fileStableInterval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			if partial {
+				return fmt.Errorf("partial file left: %w", errIncompleteDownload)
+			}
+			return context.Cause(ctx)
+		}
+	}
+}
+
+// removePartialDownload removes the download guid in dir whether or
+// not the browser finished it.
+//
+// The browser can create the partial file after it reports the
+// download cancelled so it keeps removing it for a second.
+func removePartialDownload(dir, guid string) {
+	path := filepath.Join(dir, guid)
+	for i := 0; i < 4; i++ {
+		_ = os.Remove(path)
+		_ = os.Remove(path + ".crdownload")
+		time.Sleep(fileStableInterval)
+	}
+}
+
+// renameDownload renames the file the browser saved as guid in dir
+// to include the photo ID and the extension of suggestedName so it
+// can be identified in the logs and the -keep-downloads directory.
+//
+// It returns the new path.
+func renameDownload(dir, photoID, guid, suggestedName string) (string, error) {
+	src := filepath.Join(dir, guid)
+	ext := filepath.Ext(filepath.Base(suggestedName))
+	if len(ext) > 16 || strings.ContainsAny(ext, `/\:`) {
+		ext = ""
+	}
+	name := photoID + "-" + guid
+	dst := filepath.Join(dir, name+ext)
+	for i := 1; ; i++ {
+		_, err := os.Lstat(dst)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		dst = filepath.Join(dir, fmt.Sprintf("%s-%d%s", name, i, ext))
+	}
+	err := os.Rename(src, dst)
+	if err != nil {
+		_ = os.Remove(src)
+		return "", fmt.Errorf("failed to rename download: %w", err)
+	}
+	return dst, nil
+}
+
+// checkWedged counts download failures which look like the browser's
+// fault, restarting it after -max-consecutive-failures in a row.
+//
+// Call with mu held.
+func (g *Gphotos) checkWedged(err error) {
+	if *maxFailures <= 0 || errorStatus(err) < http.StatusInternalServerError {
+		return
+	}
+	g.consecutiveFailures++
+	if g.consecutiveFailures < *maxFailures {
+		return
+	}
+	g.consecutiveFailures = 0
+	reason := fmt.Sprintf("%d consecutive download failures, last: %v", *maxFailures, err)
+	err = g.restartBrowser(reason)
+	if err != nil {
+		slog.Error("Failed to restart browser", "err", err)
+	}
+}
+
+// restartBrowser closes the browser and starts a new one.
+//
+// If the new one fails to start the browser is left asleep so the next
+// request tries to start it again with wake.
+//
+// Call with mu held so no downloads are in progress.
+func (g *Gphotos) restartBrowser(reason string) error {
+	slog.Info("Restarting browser", "reason", reason)
+	g.restart.begin()
+	defer g.restart.end()
+	// Wait for the metadata requests using the browser
+	g.life.Lock()
+	defer g.life.Unlock()
+	g.closeBrowser()
+	err := g.startBrowser()
+	if err != nil {
+		g.asleep.Store(true)
+		g.browserPID = 0
+		return err
+	}
+	err = g.fillTabPool()
+	if err != nil {
+		g.closeBrowser()
+		g.asleep.Store(true)
+		g.browserPID = 0
+		return err
+	}
+	slog.Info("Restarted browser")
+	return nil
+}
+
+// closeBrowser closes the tabs and the browser
+func (g *Gphotos) closeBrowser() {
+	g.drainTabPool()
 	err := g.browser.Close()
 	if err == nil {
 		slog.Debug("Closed browser")
@@ -398,26 +1647,85 @@ func (g *Gphotos) Close() {
 	}
 }
 
+// shutdownServers stops the web servers waiting up to timeout for
+// requests in progress to finish
+func (g *Gphotos) shutdownServers(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, server := range g.servers {
+		err := server.Shutdown(ctx)
+		if err != nil {
+			slog.Error("Failed to shut down web server cleanly", "err", err)
+		}
+	}
+}
+
+// Close the browser
+func (g *Gphotos) Close() {
+	close(g.done)
+	g.shutdownServers(shutdownTimeout)
+	slog.Info(fmt.Sprintf("Served %d photos, %d failed", g.served.Load(), g.failed.Load()), "served", g.served.Load(), "failed", g.failed.Load())
+	g.closeWorkers()
+	g.life.Lock()
+	if !g.asleep.Load() {
+		g.closeBrowser()
+	}
+	g.life.Unlock()
+	err := os.RemoveAll(g.downloadDir)
+	if err != nil {
+		slog.Error("Failed to remove download directory partition", "dir", g.downloadDir, "err", err)
+	}
+}
+
 func main() {
 	err := config()
 	if err != nil {
 		slog.Error("Configuration failed", "err", err)
-		os.Exit(2)
+		os.Exit(exitConfig)
 	}
 	defer removeDownloadDirectory()
 
 	g, err := New()
 	if err != nil {
-		slog.Error("Failed to start application", "err", err)
-		os.Exit(2)
+		slog.Error("Failed to start application", "err", err, "exit_code", exitCode(err))
+		os.Exit(exitCode(err))
 	}
 	defer g.Close()
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, exitSignals...)
 
+	reload := make(chan os.Signal, 1)
+	if len(reloadSignals) > 0 {
+		signal.Notify(reload, reloadSignals...)
+	}
+
+	lifetime := newLifetimeTimer()
+
 	// Wait for CTRL-C or SIGTERM
 	slog.Info("Server is running. Press CTRL-C (or kill) to quit.")
-	sig := <-quit
-	slog.Info("Signal received - shutting down", "signal", sig)
-}
\ No newline at end of file
+	for {
+		select {
+		case <-lifetime.C():
+			if *lifetimeAction == lifetimeRestart {
+				g.restartAll()
+				lifetime = newLifetimeTimer()
+				continue
+			}
+			slog.Info("Reached -max-lifetime - waiting for downloads to finish then exiting", "max_lifetime", *maxLifetime)
+			g.shutdownServers(*downloadTimeout + shutdownTimeout)
+			return
+		case sig := <-reload:
+			slog.Info("Signal received - reloading", "signal", sig)
+			if g.allow != nil {
+				err := g.allow.load()
+				if err != nil {
+					slog.Error("Failed to reload allowlist - keeping the old one", "err", err)
+				}
+			}
+		case sig := <-quit:
+			slog.Info("Signal received - shutting down", "signal", sig)
+			return
+		}
+	}
+}
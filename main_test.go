@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-rod/rod"
+)
+
+// Run with -race to check the main page can be read while it is
+// replaced
+func TestMainPageConcurrent(t *testing.T) {
+	g := &Gphotos{}
+	pages := []*rod.Page{{}, {}}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if j%2 == i%2 {
+					g.setMainPage(pages[j%len(pages)])
+				} else if page := g.mainPage(); page != nil && page != pages[0] && page != pages[1] {
+					t.Errorf("main page %p isn't one of those set", page)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// getPhoto fetches path from srv returning the response and body
+func getPhoto(t *testing.T, srv string, path string) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.Get(srv + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+// checkEmptyDir fails the test if dir has any files in
+func checkEmptyDir(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("file %q left in %s", entry.Name(), dir)
+	}
+}
+
+func TestGetIDServesPhoto(t *testing.T) {
+	d := newFakeDownloader(t)
+	g := newTestGphotos(t, d)
+	srv := newTestServer(t, g)
+
+	resp, body := getPhoto(t, srv.URL, "/id/"+testPhotoID)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	if !bytes.Equal(body, testPhoto) {
+		t.Errorf("body %q, want %q", body, testPhoto)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "image/jpeg" {
+		t.Errorf("Content-Type %q, want image/jpeg", got)
+	}
+	if got := resp.Header.Get("ETag"); got == "" {
+		t.Error("no ETag")
+	}
+	if got, want := resp.Header.Get("Content-Disposition"), `inline; filename=IMG_0001.jpg`; got != want {
+		t.Errorf("Content-Disposition %q, want %q", got, want)
+	}
+	if got := g.served.Load(); got != 1 {
+		t.Errorf("served %d, want 1", got)
+	}
+	// The download is removed once served
+	if files := d.files(t); len(files) != 0 {
+		t.Errorf("files left after serving: %q", files)
+	}
+}
+
+func TestDownloadFromFakeGoogle(t *testing.T) {
+	google := newFakeGoogle(t)
+	g := startTestBrowser(t, google)
+
+	path, err := g.Download(testPhotoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, testPhoto) {
+		t.Errorf("downloaded %q, want %q", data, testPhoto)
+	}
+	if got := google.downloads.Load(); got != 1 {
+		t.Errorf("%d downloads from Google, want 1", got)
+	}
+	_ = os.Remove(path)
+}
+
+// Downloads are allowed when the browser starts and WaitDownload puts
+// that back after each one, so check the second download isn't blocked.
+func TestDownloadTwiceFromFakeGoogle(t *testing.T) {
+	google := newFakeGoogle(t)
+	g := startTestBrowser(t, google)
+
+	for i := 0; i < 2; i++ {
+		path, err := g.Download(testPhotoID)
+		if err != nil {
+			t.Fatalf("download %d: %v", i+1, err)
+		}
+		_ = os.Remove(path)
+	}
+	if got := google.downloads.Load(); got != 2 {
+		t.Errorf("%d downloads from Google, want 2", got)
+	}
+}
+
+func TestGetIDWithBrowser(t *testing.T) {
+	google := newFakeGoogle(t)
+	g := startTestBrowser(t, google)
+	srv := newTestServer(t, g)
+
+	resp, body := getPhoto(t, srv.URL, "/id/"+testPhotoID)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	if !bytes.Equal(body, testPhoto) {
+		t.Errorf("body %q, want %q", body, testPhoto)
+	}
+	if got, want := resp.Header.Get("Content-Disposition"), `inline; filename=IMG_0001.jpg`; got != want {
+		t.Errorf("Content-Disposition %q, want %q", got, want)
+	}
+	checkEmptyDir(t, g.downloadDir)
+}
+
+// Run with -race to check /health and /debug/tabs don't race with the
+// browser being restarted or put to sleep.
+func TestHealthDuringRestart(t *testing.T) {
+	google := newFakeGoogle(t)
+	g := startTestBrowser(t, google)
+	setFlag(t, "debug", "true")
+	srv := newTestServer(t, g)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for _, path := range []string{"/health", "/debug/tabs"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				resp, err := http.Get(srv.URL + path)
+				if err != nil {
+					errs <- err
+					return
+				}
+				_, _ = io.Copy(io.Discard, resp.Body)
+				_ = resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					errs <- fmt.Errorf("%s: status %d", path, resp.StatusCode)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		g.mu.Lock()
+		err := g.restartBrowser("test")
+		if err == nil {
+			g.asleep.Store(true)
+			g.life.Lock()
+			g.closeBrowser()
+			g.life.Unlock()
+			err = g.wake()
+		}
+		g.mu.Unlock()
+		if err != nil {
+			t.Error(err)
+			break
+		}
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestDownloadMissingFromFakeGoogle(t *testing.T) {
+	google := newFakeGoogle(t)
+	g := startTestBrowser(t, google)
+
+	_, err := g.Download(testMissingID)
+	if err == nil {
+		t.Fatal("download of missing photo succeeded")
+	}
+	if got := errorStatus(err); got != http.StatusNotFound {
+		t.Errorf("status %d, want 404: %v", got, err)
+	}
+	if !strings.Contains(err.Error(), testMissingID) {
+		t.Errorf("error %q doesn't mention the photo ID", err)
+	}
+	if got := google.downloads.Load(); got != 0 {
+		t.Errorf("%d downloads from Google, want 0", got)
+	}
+	checkEmptyDir(t, g.downloadDir)
+}
+
+func TestGetIDMissingWithBrowser(t *testing.T) {
+	google := newFakeGoogle(t)
+	g := startTestBrowser(t, google)
+	srv := newTestServer(t, g)
+
+	resp, body := getPhoto(t, srv.URL, "/id/"+testMissingID)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status %d, want 404: %s", resp.StatusCode, body)
+	}
+	if !bytes.Contains(body, []byte(`"code":"`+codePhotoNotFound+`"`)) {
+		t.Errorf("body %s, want code %s", body, codePhotoNotFound)
+	}
+	if got := g.failed.Load(); got != 1 {
+		t.Errorf("failed %d, want 1", got)
+	}
+}
+
+// With -etag metadata a conditional request is answered from the info
+// panel without downloading the photo again
+func TestGetIDMetadataETag(t *testing.T) {
+	google := newFakeGoogle(t)
+	g := startTestBrowser(t, google)
+	setFlag(t, "etag", etagMetadata)
+	srv := newTestServer(t, g)
+
+	resp, body := getPhoto(t, srv.URL, "/id/"+testPhotoID)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	etag := resp.Header.Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("ETag %q, want a weak one from the metadata", etag)
+	}
+
+	// Forget the ETags served as after a restart
+	g.etags = etagCache{}
+	req, err := http.NewRequest("GET", srv.URL+"/id/"+testPhotoID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("status %d, want 304", resp.StatusCode)
+	}
+	if got := google.downloads.Load(); got != 1 {
+		t.Errorf("%d downloads from Google, want 1", got)
+	}
+}
+
+func TestGetIDErrorStatus(t *testing.T) {
+	d := newFakeDownloader(t)
+	g := newTestGphotos(t, d)
+	srv := newTestServer(t, g)
+
+	for i, test := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{withCode(codeNotAuthenticated, errors.New("logged out")), http.StatusServiceUnavailable, codeNotAuthenticated},
+		{withCode(codeStorageFull, errors.New("full")), http.StatusPaymentRequired, codeStorageFull},
+		{withCode(codeTooLarge, errors.New("too big")), http.StatusRequestEntityTooLarge, codeTooLarge},
+		{fmt.Errorf("download: %w", context.DeadlineExceeded), http.StatusGatewayTimeout, codeDownloadTimeout},
+		{errors.New("browser crashed"), http.StatusInternalServerError, codeInternal},
+	} {
+		d.setError(testPhotoID, test.err)
+		resp, body := getPhoto(t, srv.URL, "/id/"+testPhotoID)
+		if resp.StatusCode != test.status {
+			t.Errorf("%v: status %d, want %d", test.err, resp.StatusCode, test.status)
+		}
+		if !bytes.Contains(body, []byte(`"code":"`+test.code+`"`)) {
+			t.Errorf("%v: body %s, want code %s", test.err, body, test.code)
+		}
+		if got := g.failed.Load(); got != int64(i+1) {
+			t.Errorf("failed %d, want %d", got, i+1)
+		}
+	}
+	if got := g.served.Load(); got != 0 {
+		t.Errorf("served %d, want 0", got)
+	}
+	if files := d.files(t); len(files) != 0 {
+		t.Errorf("files left after errors: %q", files)
+	}
+}
+
+func TestGetIDNotModified(t *testing.T) {
+	d := newFakeDownloader(t)
+	g := newTestGphotos(t, d)
+	srv := newTestServer(t, g)
+
+	resp, _ := getPhoto(t, srv.URL, "/id/"+testPhotoID)
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	req, err := http.NewRequest("GET", srv.URL+"/id/"+testPhotoID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("status %d, want 304", resp.StatusCode)
+	}
+	if got := d.calls.Load(); got != 1 {
+		t.Errorf("%d downloads, want 1", got)
+	}
+}
+
+func TestGetIDRange(t *testing.T) {
+	d := newFakeDownloader(t)
+	g := newTestGphotos(t, d)
+	srv := newTestServer(t, g)
+
+	req, err := http.NewRequest("GET", srv.URL+"/id/"+testPhotoID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=4-9")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Errorf("status %d, want 206", resp.StatusCode)
+	}
+	if want := testPhoto[4:10]; !bytes.Equal(body, want) {
+		t.Errorf("body %q, want %q", body, want)
+	}
+	if files := d.files(t); len(files) != 0 {
+		t.Errorf("files left after serving: %q", files)
+	}
+}
+
+// Run with -race to check the served and failed counters
+func TestServedFailedCounters(t *testing.T) {
+	d := newFakeDownloader(t)
+	g := newTestGphotos(t, d)
+	srv := newTestServer(t, g)
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*n)
+	for i := 0; i < n; i++ {
+		for _, photoID := range []string{testPhotoID, testMissingID} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := http.Get(srv.URL + "/id/" + photoID)
+				if err != nil {
+					errs <- err
+					return
+				}
+				_, _ = io.Copy(io.Discard, resp.Body)
+				_ = resp.Body.Close()
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if got := g.served.Load(); got != n {
+		t.Errorf("served %d, want %d", got, n)
+	}
+	if got := g.failed.Load(); got != n {
+		t.Errorf("failed %d, want %d", got, n)
+	}
+	// The root page shows them
+	_, body := getPhoto(t, srv.URL, "/")
+	if want := fmt.Sprintf("Served %d photos, %d failed since start", n, n); !bytes.Contains(body, []byte(want)) {
+		t.Errorf("root page doesn't contain %q", want)
+	}
+}
+
+// -lite-render stops the images loading so check the download still
+// works without the photo being shown
+func TestDownloadLiteRender(t *testing.T) {
+	setFlag(t, "lite-render", "true")
+	google := newFakeGoogle(t)
+	g := startTestBrowser(t, google)
+	setFlag(t, "ready-timeout", "3s")
+
+	path, err := g.Download(testPhotoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, testPhoto) {
+		t.Errorf("downloaded %q, want %q", data, testPhoto)
+	}
+	_ = os.Remove(path)
+}
+
+// A photo over -max-file-size is turned away from the size in the info
+// panel without being downloaded
+func TestDownloadTooLarge(t *testing.T) {
+	google := newFakeGoogle(t)
+	g := startTestBrowser(t, google)
+	setFlag(t, "max-file-size", "10")
+
+	_, err := g.Download(testPhotoID)
+	if got := errorStatus(err); got != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want 413: %v", got, err)
+	}
+	if got := google.downloads.Load(); got != 0 {
+		t.Errorf("%d downloads from Google, want 0", got)
+	}
+	checkEmptyDir(t, g.downloadDir)
+
+	// A photo under the limit still downloads
+	setFlag(t, "max-file-size", "1K")
+	path, err := g.Download(testPhotoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Remove(path)
+}
+
+// If the info panel doesn't show the size the download progress still
+// enforces -max-file-size
+func TestDownloadTooLargeUnknownSize(t *testing.T) {
+	google := newFakeGoogle(t)
+	google.hideSize.Store(true)
+	g := startTestBrowser(t, google)
+	setFlag(t, "max-file-size", "10")
+
+	_, err := g.Download(testPhotoID)
+	if got := errorStatus(err); got != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want 413: %v", got, err)
+	}
+	checkEmptyDir(t, g.downloadDir)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// memoryCheckInterval is how often the browser memory is checked
+// against -max-browser-memory
+const memoryCheckInterval = 30 * time.Second
+
+// watchMemory restarts the browser between downloads when its memory
+// use goes over -max-browser-memory until done is closed.
+func (g *Gphotos) watchMemory(done <-chan struct{}) {
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		err := g.checkMemory()
+		if errors.Is(err, errors.ErrUnsupported) {
+			slog.Warn("Can't read the browser memory on this platform - ignoring -max-browser-memory")
+			return
+		} else if err != nil {
+			slog.Error("Failed to check browser memory", "err", err)
+		}
+	}
+}
+
+// checkMemory restarts the browser if it is using more than
+// -max-browser-memory
+func (g *Gphotos) checkMemory() error {
+	// Wait for any download in progress to finish
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.browserPID == 0 {
+		return nil
+	}
+	rss, err := processTreeRSS(g.browserPID)
+	if err != nil {
+		return err
+	}
+	slog.Debug("Browser memory", "pid", g.browserPID, "rss", rss, "max_browser_memory", int64(maxBrowserMemory))
+	if rss <= int64(maxBrowserMemory) {
+		return nil
+	}
+	reason := fmt.Sprintf("browser using %d bytes of memory, more than -max-browser-memory %d", rss, int64(maxBrowserMemory))
+	return g.restartBrowser(reason)
+}
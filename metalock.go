@@ -0,0 +1,64 @@
+package main
+
+import "time"
+
+// lockMeta waits until a metadata request may use the browser, starting
+// it if it is asleep, and returns the function to call when done.
+//
+// Metadata requests open their own tabs so up to -metadata-parallel of
+// them run alongside a download rather than waiting for it. With
+// -metadata-parallel 0 they take mu like the downloads.
+func (g *Gphotos) lockMeta() (unlock func(), err error) {
+	if g.metaSem == nil {
+		g.mu.Lock()
+		err = g.wake()
+		if err != nil {
+			g.mu.Unlock()
+			return nil, err
+		}
+		return g.mu.Unlock, nil
+	}
+	g.metaSem <- struct{}{}
+	release := func() {
+		<-g.metaSem
+	}
+	g.lastUsed.Store(time.Now().UnixNano())
+	g.life.RLock()
+	if !g.asleep.Load() {
+		return func() {
+			g.life.RUnlock()
+			release()
+		}, nil
+	}
+	// The browser is asleep so start it under mu like a download
+	g.life.RUnlock()
+	g.mu.Lock()
+	err = g.wake()
+	if err == nil {
+		g.life.RLock()
+	}
+	g.mu.Unlock()
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return func() {
+		g.life.RUnlock()
+		release()
+	}, nil
+}
+
+// rlockAwake holds life for reading so the browser isn't started or
+// stopped while it is used, returning true if it is awake. If it is
+// asleep it returns false with life unlocked.
+//
+// This doesn't wake the browser so suits status requests which
+// shouldn't. Release with g.life.RUnlock.
+func (g *Gphotos) rlockAwake() bool {
+	g.life.RLock()
+	if g.asleep.Load() {
+		g.life.RUnlock()
+		return false
+	}
+	return true
+}
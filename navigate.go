@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/go-rod/rod"
+)
+
+// maxNavigateBackoff is the longest pause between navigation retries
+const maxNavigateBackoff = 10 * time.Second
+
+// Strategies for -wait-strategy to decide when a page is ready
+const (
+	waitLoad        = "load"        // the load event
+	waitNetworkIdle = "networkidle" // the load event then no requests for a while
+	waitDOM         = "dom"         // the DOM stops changing
+)
+
+// pageIdleTime is how long the page must have no requests or DOM
+// changes to be ready with the networkidle and dom strategies
+const pageIdleTime = 500 * time.Millisecond
+
+// transientNavigationErrors are the browser network errors worth
+// retrying as they are usually caused by a network blip
+var transientNavigationErrors = []string{
+	"net::ERR_CONNECTION_RESET",
+	"net::ERR_CONNECTION_CLOSED",
+	"net::ERR_CONNECTION_REFUSED",
+	"net::ERR_CONNECTION_TIMED_OUT",
+	"net::ERR_TIMED_OUT",
+	"net::ERR_NETWORK_CHANGED",
+	"net::ERR_INTERNET_DISCONNECTED",
+	"net::ERR_NAME_NOT_RESOLVED",
+	"net::ERR_ADDRESS_UNREACHABLE",
+	"net::ERR_EMPTY_RESPONSE",
+	"net::ERR_HTTP2_PROTOCOL_ERROR",
+	"net::ERR_QUIC_PROTOCOL_ERROR",
+}
+
+// isTransientNavigationError returns true if err is a navigation
+// error which may succeed if retried.
+//
+// Others, like a malformed URL, will fail again.
+func isTransientNavigationError(err error) bool {
+	var navErr *rod.NavigationError
+	if !errors.As(err, &navErr) {
+		return false
+	}
+	for _, reason := range transientNavigationErrors {
+		if strings.Contains(navErr.Reason, reason) {
+			return true
+		}
+	}
+	return false
+}
+
+// navigate page to url retrying transient errors up to
+// -navigate-retries times with exponential backoff.
+func navigate(page *rod.Page, slog *slog.Logger, url string) error {
+	backoff := time.Second
+	for try := 1; ; try++ {
+		err := page.Navigate(url)
+		if err == nil {
+			return nil
+		}
+		if !isTransientNavigationError(err) || try > *navigateRetries {
+			return err
+		}
+		slog.Warn("Navigation failed - retrying", "url", url, "try", try, "retries", *navigateRetries, "backoff", backoff, "err", err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-page.GetContext().Done():
+			timer.Stop()
+			return page.GetContext().Err()
+		}
+		backoff = min(backoff*2, maxNavigateBackoff)
+	}
+}
+
+// waitPage waits for page to be ready after navigating using the
+// -wait-strategy.
+//
+// The networkidle and dom strategies give up waiting after
+// -ready-timeout as pages which poll the network or animate never
+// settle.
+func waitPage(page *rod.Page) error {
+	switch *waitStrategy {
+	case waitNetworkIdle:
+		err := page.WaitLoad()
+		if err != nil {
+			return err
+		}
+		idle := page.Timeout(*readyTimeout)
+		idle.WaitRequestIdle(pageIdleTime, nil, nil, nil)()
+		idle.CancelTimeout()
+		return nil
+	case waitDOM:
+		stable := page.Timeout(*readyTimeout)
+		defer stable.CancelTimeout()
+		err := stable.WaitDOMStable(pageIdleTime, 0)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil
+		}
+		return err
+	}
+	return page.WaitLoad()
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/input"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// photoIDFormat describes the photo IDs accepted
+const photoIDFormat = "16 to 128 characters from A-Z, a-z, 0-9, - and _"
+
+// photoIDRe matches a valid photo ID
+var photoIDRe = regexp.MustCompile(`^[A-Za-z0-9_-]{16,128}$`)
+
+// checkPhotoID writes an error and returns false if photoID is
+// malformed or not in the allowlist.
+func (g *Gphotos) checkPhotoID(w http.ResponseWriter, photoID string) bool {
+	if !photoIDRe.MatchString(photoID) {
+		slog.Warn("Malformed photo ID", "id", photoID)
+		writeErrorCode(w, codeBadRequest, fmt.Sprintf("malformed photo ID %q - expecting %s", photoID, photoIDFormat))
+		return false
+	}
+	return g.checkAllowed(w, photoID)
+}
+
+// openPhoto opens a new tab bound to ctx and navigates it to the
+// page for photoID.
+//
+// The tab should be closed with closeTab after use.
+func (g *Gphotos) openPhoto(ctx context.Context, slog *slog.Logger, photoID string) (*rod.Page, error) {
+	url, ref := g.photoPageURL(photoID)
+
+	// Create a new blank browser tab
+	slog.Debug("Open new tab")
+	page, err := g.newTab(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open browser tab for photo %q: %w", photoID, err)
+	}
+
+	// Watch for the response to the photo page
+	status := 0
+	waitResponse := page.EachEvent(func(e *proto.NetworkResponseReceived) bool {
+		if e.Type != proto.NetworkResourceTypeDocument {
+			return false
+		}
+		status = e.Response.Status
+		return true
+	})
+
+	// Navigate to the photo URL
+	slog.Debug("Navigate to photo URL")
+	err = navigate(page, slog, url)
+	if err != nil {
+		g.closeTab(page)
+		return nil, fmt.Errorf("failed to navigate to photo %q: %w", photoID, err)
+	}
+	waitResponse()
+	slog.Debug("Photo page response", "status", status)
+
+	err = waitPage(page)
+	if err != nil {
+		g.closeTab(page)
+		return nil, fmt.Errorf("gphoto page load: %w", err)
+	}
+
+	_, err = dismissConsent(page, slog)
+	if err != nil {
+		g.closeTab(page)
+		return nil, err
+	}
+
+	err = g.checkAccount(page, slog)
+	if err != nil {
+		g.closeTab(page)
+		return nil, err
+	}
+
+	err = checkPhotoPage(page, photoID, status, ref)
+	if err != nil && ref == nil && status != http.StatusNotFound && status != http.StatusGone && *gridSearch > 0 && onGrid(page) {
+		// Some IDs only resolve from the grid
+		err = findInGrid(page, slog, photoID)
+	}
+	if err != nil {
+		g.closeTab(page)
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// onGrid returns true if page is showing the library grid
+func onGrid(page *rod.Page) bool {
+	info, err := page.Info()
+	if err != nil {
+		return false
+	}
+	return strings.TrimSuffix(info.URL, "/")+"/" == *gphotosURL
+}
+
+// findInGrid scrolls the library grid on page for up to
+// -grid-search-timeout looking for photoID and opens it, returning a
+// not found error if it isn't there.
+func findInGrid(page *rod.Page, slog *slog.Logger, photoID string) error {
+	slog.Info("Photo page bounced to the grid - searching it for the photo")
+	start := time.Now()
+	found := false
+	grid := page.Timeout(*gridSearch)
+	err := scrollPhotoIDs(grid, slog, func(id string) bool {
+		found = id == photoID
+		return !found
+	})
+	grid.CancelTimeout()
+	if !found {
+		if err != nil {
+			slog.Debug("Grid search failed", "err", err)
+		}
+		return fmt.Errorf("photo %q not found in the grid after %v: %w", photoID, time.Since(start).Round(time.Second), httpError(http.StatusNotFound))
+	}
+	err = clickPhotoLink(page, photoID)
+	if err == nil {
+		err = page.WaitStable(time.Second)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open photo %q from the grid: %w", photoID, err)
+	}
+	slog.Info("Opened photo from the grid", "elapsed", time.Since(start).Round(time.Millisecond))
+	return checkPhotoPage(page, photoID, http.StatusOK, nil)
+}
+
+// Views which can load when navigating to a photo
+const (
+	viewPhoto     = "photo"     // the single photo view we need
+	viewGrid      = "grid"      // a grid of photos
+	viewSlideshow = "slideshow" // a slideshow
+)
+
+// detectViewJS works out which view the page is showing
+const detectViewJS = `(photoID) => {
+	if (location.href.includes("slideshow") || document.querySelector('[aria-label*="slideshow" i][aria-pressed="true"]')) {
+		return "slideshow";
+	}
+	const links = document.querySelectorAll('a[href*="/photo/"]');
+	if (location.href.includes("/photo/" + photoID) && links.length <= 1) {
+		return "photo";
+	}
+	if (links.length > 1) {
+		return "grid";
+	}
+	return "unknown";
+}`
+
+// detectView returns which view page is showing for photoID
+func detectView(page *rod.Page, photoID string) (string, error) {
+	res, err := page.Eval(detectViewJS, photoID)
+	if err != nil {
+		return "", fmt.Errorf("failed to detect view: %w", err)
+	}
+	return res.Value.Str(), nil
+}
+
+// ensurePhotoView checks page is showing the single photo view for
+// photoID, trying to get there from the grid or slideshow if not.
+func ensurePhotoView(page *rod.Page, slog *slog.Logger, photoID string) error {
+	view, err := detectView(page, photoID)
+	if err != nil {
+		return err
+	}
+	slog.Debug("Detected view", "view", view)
+	switch view {
+	case viewPhoto:
+		return nil
+	case viewSlideshow:
+		// Escape leaves the slideshow on the current photo
+		err = page.Keyboard.Type(input.Escape)
+	case viewGrid:
+		// Open the photo from the grid
+		err = clickPhotoLink(page, photoID)
+	default:
+		// Let the download have a go
+		slog.Warn("Unknown view loaded for photo", "view", view)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to leave %s view: %w", view, err)
+	}
+	err = page.WaitStable(time.Second)
+	if err != nil {
+		return fmt.Errorf("page load after leaving %s view: %w", view, err)
+	}
+	newView, err := detectView(page, photoID)
+	if err != nil {
+		return err
+	}
+	if newView != viewPhoto {
+		return fmt.Errorf("photo %q loaded in %s view and couldn't switch to photo view (got %s)", photoID, view, newView)
+	}
+	slog.Info("Switched to photo view", "from", view)
+	return nil
+}
+
+// clickPhotoLink clicks the link to photoID in a grid
+func clickPhotoLink(page *rod.Page, photoID string) error {
+	page = page.Timeout(*readyTimeout)
+	defer page.CancelTimeout()
+	el, err := page.Element(`a[href*="/photo/` + photoID + `"]`)
+	if err != nil {
+		return err
+	}
+	return el.Click(proto.InputMouseButtonLeft, 1)
+}
+
+// photoReadySelector matches the photo or video being shown. The
+// media is served from googleusercontent.com.
+const photoReadySelector = `img[src*="googleusercontent.com"], video`
+
+// waitPhotoReady waits up to -ready-timeout for the photo on page to
+// be visible.
+//
+// With -lite-render the images never load so may never be shown, so
+// it only waits for the photo to be in the page.
+func waitPhotoReady(page *rod.Page, slog *slog.Logger) error {
+	slog.Debug("Wait for photo to be shown")
+	start := time.Now()
+	page = page.Timeout(*readyTimeout)
+	defer page.CancelTimeout()
+	el, err := page.Element(photoReadySelector)
+	if err == nil && !*liteRender {
+		err = el.WaitVisible()
+	}
+	if err != nil {
+		return fmt.Errorf("photo not shown after %v: %w", time.Since(start).Round(time.Millisecond), err)
+	}
+	slog.Debug("Photo shown", "elapsed", time.Since(start))
+	return nil
+}
+
+// Methods for starting the download selected with -download-method
+const (
+	methodKeypress = "keypress" // press Shift-D
+	methodClick    = "click"    // click the download item in the more options menu
+)
+
+// findElement waits for the element matching selector on page which is
+// XPath if it starts with / or ( and CSS otherwise.
+func findElement(page *rod.Page, selector string) (*rod.Element, error) {
+	if strings.HasPrefix(selector, "/") || strings.HasPrefix(selector, "(") {
+		return page.ElementX(selector)
+	}
+	return page.Element(selector)
+}
+
+// startDownload starts the download of the photo shown on page using
+// the -download-method.
+func startDownload(page *rod.Page, slog *slog.Logger) error {
+	if *downloadMethod != methodClick {
+		// Shift-D to download
+		err := page.KeyActions().Press(input.ShiftLeft).Type('D').Do()
+		if err != nil {
+			return fmt.Errorf("failed to send download keypress: %w", err)
+		}
+		return nil
+	}
+
+	page = page.Timeout(*readyTimeout)
+	defer page.CancelTimeout()
+	slog.Debug("Open more options menu", "selector", *menuSelector)
+	menu, err := findElement(page, *menuSelector)
+	if err != nil {
+		return fmt.Errorf("more options button not found - set -menu-selector to match it: %w", err)
+	}
+	err = menu.Click(proto.InputMouseButtonLeft, 1)
+	if err != nil {
+		return fmt.Errorf("failed to click more options button: %w", err)
+	}
+	slog.Debug("Click download menu item", "selector", *itemSelector)
+	item, err := findElement(page, *itemSelector)
+	if err != nil {
+		return fmt.Errorf("download menu item not found - set -download-selector to match it: %w", err)
+	}
+	err = item.Click(proto.InputMouseButtonLeft, 1)
+	if err != nil {
+		return fmt.Errorf("failed to click download menu item: %w", err)
+	}
+	return nil
+}
+
+// fileSizeTimeout is how long checkFileSize waits for the info panel
+// to show the file size
+const fileSizeTimeout = 2 * time.Second
+
+// checkFileSize reads the size of photoID shown on page from the info
+// panel and returns a too large error if it is bigger than
+// -max-file-size so the download is never started.
+//
+// If the size can't be read, eg the page is in another language, it
+// returns nil leaving the download progress to enforce the limit.
+func checkFileSize(page *rod.Page, slog *slog.Logger, photoID string) error {
+	// "i" toggles the info panel
+	err := page.Keyboard.Type('i')
+	if err != nil {
+		return fmt.Errorf("failed to open info panel: %w", err)
+	}
+	defer func() {
+		err := page.Keyboard.Type('i')
+		if err != nil {
+			slog.Debug("Failed to close info panel", "err", err)
+		}
+	}()
+	timed := page.Timeout(fileSizeTimeout)
+	defer timed.CancelTimeout()
+	el, err := timed.Element(`[aria-label^="File size:"]`)
+	if err != nil {
+		slog.Debug("File size not shown - checking it while downloading", "err", err)
+		return nil
+	}
+	label, err := el.Attribute("aria-label")
+	if err != nil || label == nil {
+		slog.Debug("Failed to read file size - checking it while downloading", "err", err)
+		return nil
+	}
+	size, err := parseFileSize(strings.TrimPrefix(*label, "File size:"))
+	if err != nil {
+		slog.Debug("Failed to parse file size - checking it while downloading", "err", err)
+		return nil
+	}
+	if size > int64(maxFileSize) {
+		slog.Warn("Photo too large - not downloading", "size", size, "max_file_size", int64(maxFileSize))
+		return fmt.Errorf("photo %q is larger than -max-file-size %d: %w", photoID, int64(maxFileSize), httpError(http.StatusRequestEntityTooLarge))
+	}
+	slog.Debug("Photo size within -max-file-size", "size", size)
+	return nil
+}
+
+// parseFileSize parses a size as shown in the info panel, eg "2.3 MB"
+// or "845 KB"
+func parseFileSize(s string) (int64, error) {
+	var size sizeValue
+	err := size.Set(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		return 0, err
+	}
+	return int64(size), nil
+}
+
+// checkPhotoPage checks the page loaded for photoID is showing the
+// photo, returning a not found error if the photo doesn't exist or a
+// forbidden error if it is shared or in the Locked Folder, as given by
+// ref, and the session can't access it.
+//
+// Google Photos either returns an error status or redirects away from
+// the photo URL for deleted or invalid photos.
+func checkPhotoPage(page *rod.Page, photoID string, status int, ref *shareRef) error {
+	notFound := status == http.StatusNotFound || status == http.StatusGone
+	if !notFound {
+		info, err := page.Info()
+		if err != nil {
+			return fmt.Errorf("failed to read photo page info: %w", err)
+		}
+		if strings.Contains(info.URL, photoID) {
+			return nil
+		}
+		slog.Debug("Redirected away from photo", "id", photoID, "url", info.URL)
+	}
+	switch {
+	case ref != nil && ref.locked:
+		// The Locked Folder needs unlocking with the device auth
+		return withCode(codeLockedFolder, fmt.Errorf("can't open photo %q in the Locked Folder - run with -login and unlock it", photoID))
+	case ref != nil:
+		// A shared photo which exists but redirects means the
+		// session can't see the share
+		return fmt.Errorf("no access to shared photo %q - check the share and key: %w", photoID, httpError(http.StatusForbidden))
+	}
+	return fmt.Errorf("photo %q not found - if it is in a shared album pass ?share= and ?key= from the share link, or ?locked=true if it is in the Locked Folder: %w", photoID, httpError(http.StatusNotFound))
+}
+
+// PhotoMeta is the metadata read from the info panel of a photo
+type PhotoMeta struct {
+	ID          string `json:"id"`
+	Filename    string `json:"filename,omitempty"`
+	Date        string `json:"date,omitempty"`
+	Size        string `json:"size,omitempty"`
+	Dimensions  string `json:"dimensions,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// readMetaJS reads the fields of the info panel. Google Photos labels
+// each field with an aria-label of the form "Label: value".
+const readMetaJS = `() => {
+	const get = (prefix) => {
+		const el = document.querySelector('[aria-label^="' + prefix + '"]');
+		return el ? el.getAttribute("aria-label").slice(prefix.length).trim() : "";
+	};
+	const desc = document.querySelector('textarea[aria-label="Description"]');
+	return {
+		filename: get("Filename:"),
+		date: get("Date taken:"),
+		size: get("File size:"),
+		dimensions: get("Dimensions:"),
+		description: desc ? desc.value.trim() : "",
+	};
+}`
+
+// Meta reads the metadata for photoID from the info panel without
+// downloading the photo.
+func (g *Gphotos) Meta(photoID string) (*PhotoMeta, error) {
+	slog := slog.With("id", photoID)
+	unlock, err := g.lockMeta()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *downloadTimeout)
+	defer cancel()
+
+	page, err := g.openPhoto(ctx, slog, photoID)
+	if err != nil {
+		return nil, err
+	}
+	defer g.closeTab(page)
+
+	// "i" toggles the info panel
+	slog.Debug("Open info panel")
+	err = page.Keyboard.Type('i')
+	if err != nil {
+		return nil, fmt.Errorf("failed to open info panel: %w", err)
+	}
+	err = page.WaitStable(time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("info panel load: %w", err)
+	}
+
+	res, err := page.Eval(readMetaJS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read info panel: %w", err)
+	}
+	meta := &PhotoMeta{ID: photoID}
+	err = res.Value.Unmarshal(meta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode info panel: %w", err)
+	}
+	meta.ID = photoID
+	slog.Debug("Read photo metadata", "meta", meta)
+	return meta, nil
+}
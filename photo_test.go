@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckPhotoID(t *testing.T) {
+	g := newTestGphotos(t, newFakeDownloader(t))
+	for _, test := range []struct {
+		photoID string
+		ok      bool
+	}{
+		{testPhotoID, true},
+		{"AF1QipN-abc_DEF0123456789", true},
+		{strings.Repeat("a", 16), true},
+		{strings.Repeat("a", 128), true},
+		{strings.Repeat("a", 15), false},
+		{strings.Repeat("a", 129), false},
+		{"", false},
+		{"AF1QipTestPhoto00000/../", false},
+		{"AF1QipTestPhoto0000000001.jpg", false},
+		{"AF1QipTestPhoto 000000001", false},
+		{"AF1QipTestPhoto%00000001", false},
+		{"AF1QipTestPhötö000000001", false},
+	} {
+		rec := httptest.NewRecorder()
+		ok := g.checkPhotoID(rec, test.photoID)
+		if ok != test.ok {
+			t.Errorf("checkPhotoID(%q) = %v, want %v", test.photoID, ok, test.ok)
+			continue
+		}
+		if ok {
+			continue
+		}
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("checkPhotoID(%q) gave %d, want 400", test.photoID, rec.Code)
+		}
+		if body := rec.Body.String(); !strings.Contains(body, photoIDFormat) {
+			t.Errorf("error %q doesn't give the expected format", body)
+		}
+	}
+}
+
+func TestGetIDMalformedSkipsDownload(t *testing.T) {
+	d := newFakeDownloader(t)
+	g := newTestGphotos(t, d)
+	srv := newTestServer(t, g)
+
+	resp, body := getPhoto(t, srv.URL, "/id/not-an-id")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status %d, want 400: %s", resp.StatusCode, body)
+	}
+	if got := d.calls.Load(); got != 0 {
+		t.Errorf("%d downloads tried for a malformed ID, want 0", got)
+	}
+}
+
+func TestParseFileSize(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{" 46 B", 46, true},
+		{"845 KB", 845 << 10, true},
+		{"2.5 MB", 5 << 19, true},
+		{"1 GB", 1 << 30, true},
+		{"", 0, false},
+		{"unknown", 0, false},
+	} {
+		got, err := parseFileSize(test.in)
+		if (err == nil) != test.ok {
+			t.Errorf("parseFileSize(%q) error %v, want ok %v", test.in, err, test.ok)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseFileSize(%q) = %d, want %d", test.in, got, test.want)
+		}
+	}
+}
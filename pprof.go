@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+// startPprof serves the profiling endpoints on -pprof which must be a
+// loopback address as they expose the internals of the process.
+func startPprof() error {
+	if *pprofAddr == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(*pprofAddr)
+	if err != nil {
+		return fmt.Errorf("bad -pprof address: %w", err)
+	}
+	if host != "localhost" {
+		ip := net.ParseIP(host)
+		if ip == nil || !ip.IsLoopback() {
+			return fmt.Errorf("-pprof address %q must be on localhost", *pprofAddr)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	listener, err := net.Listen("tcp", *pprofAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %q: %w", *pprofAddr, err)
+	}
+	slog.Info("Serving pprof", "url", "http://"+listener.Addr().String()+"/debug/pprof/")
+	go func() {
+		server := &http.Server{Handler: mux, ReadHeaderTimeout: readHeaderTimeout}
+		err := server.Serve(listener)
+		if err != nil {
+			slog.Error("pprof server failed", "err", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"os"
+)
+
+// processAlive returns true if the process with pid is running
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = p.Release()
+	return true
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// reserveQueue takes a place in the queue of downloads waiting for the
+// browser returning an error if -max-queue are already waiting.
+//
+// The place is taken atomically so concurrent requests can't overfill
+// the queue. Release it with releaseQueue once the browser is free.
+func (s *stats) reserveQueue() error {
+	queued := s.queued.Add(1)
+	if *maxQueue <= 0 || queued <= int64(*maxQueue) {
+		return nil
+	}
+	s.queued.Add(-1)
+	// Estimate the time for the queue to drain by one
+	wait := s.averageDuration()
+	if wait <= 0 {
+		wait = defaultRestartTime
+	}
+	return withRetryAfter(wait, withCode(codeQueueFull, fmt.Errorf("download queue full - %d waiting", queued-1)))
+}
+
+// releaseQueue gives up the place taken by reserveQueue
+func (s *stats) releaseQueue() {
+	s.queued.Add(-1)
+}
+
+// averageDuration returns the average duration of the downloads
+func (s *stats) averageDuration() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := s.downloads
+	for _, n := range s.failures {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	return s.totalDuration / time.Duration(total)
+}
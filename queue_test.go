@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Run with -race
+func TestReserveQueue(t *testing.T) {
+	setFlag(t, "max-queue", "5")
+	s := new(stats)
+	s.reset()
+
+	var wg sync.WaitGroup
+	var reserved, rejected atomic.Int64
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.reserveQueue()
+			if err == nil {
+				reserved.Add(1)
+			} else if errorCode(err) == codeQueueFull {
+				rejected.Add(1)
+			} else {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if reserved.Load() != 5 || rejected.Load() != 95 {
+		t.Errorf("reserved %d and rejected %d, want 5 and 95", reserved.Load(), rejected.Load())
+	}
+	if got := s.queued.Load(); got != 5 {
+		t.Errorf("queued %d, want 5", got)
+	}
+	s.releaseQueue()
+	if err := s.reserveQueue(); err != nil {
+		t.Errorf("reserve after release: %v", err)
+	}
+}
+
+func TestQueueFullRetryAfter(t *testing.T) {
+	setFlag(t, "max-queue", "1")
+	s := new(stats)
+	s.reset()
+	if err := s.reserveQueue(); err != nil {
+		t.Fatal(err)
+	}
+	err := s.reserveQueue()
+	rec := httptest.NewRecorder()
+	writeError(rec, err)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want 503", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != strconv.Itoa(int(defaultRestartTime.Seconds())) {
+		t.Errorf("Retry-After %q, want the default estimate", got)
+	}
+	if e := readError(t, rec); e.Code != codeQueueFull {
+		t.Errorf("code %q, want %q", e.Code, codeQueueFull)
+	}
+
+	// The estimate comes from the downloads done
+	s.record(3*time.Second, nil)
+	rec = httptest.NewRecorder()
+	writeError(rec, s.reserveQueue())
+	if got := rec.Header().Get("Retry-After"); got != "3" {
+		t.Errorf("Retry-After %q, want 3", got)
+	}
+}
+
+func TestGetIDQueueFullWithBrowser(t *testing.T) {
+	google := newFakeGoogle(t)
+	g := startTestBrowser(t, google)
+	setFlag(t, "max-queue", "2")
+	srv := newTestServer(t, g)
+
+	// Hold the browser so the downloads queue up behind it
+	g.mu.Lock()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, body := getPhoto(t, srv.URL, "/id/"+testPhotoID)
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("queued download: status %d: %s", resp.StatusCode, body)
+			}
+		}()
+	}
+	for g.stats.queued.Load() < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	resp, body := getPhoto(t, srv.URL, "/id/"+testPhotoID)
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("status %d Retry-After %q, want 503 with Retry-After: %s", resp.StatusCode, resp.Header.Get("Retry-After"), body)
+	}
+	g.mu.Unlock()
+	wg.Wait()
+	if got := g.stats.queued.Load(); got != 0 {
+		t.Errorf("queued %d after the downloads, want 0", got)
+	}
+	if got := google.downloads.Load(); got != 2 {
+		t.Errorf("%d downloads from Google, want 2", got)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRateBuckets is the number of client IPs tracked before idle
+// ones are pruned
+const maxRateBuckets = 10000
+
+// bucket is a token bucket for one client IP
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket rate limiter keyed by client IP
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens added per second
+	burst   float64 // maximum tokens in a bucket
+	buckets map[string]*bucket
+	exempt  []netip.Prefix // client IPs which bypass the limiter
+}
+
+// newRateLimiter makes a rate limiter from the flags.
+//
+// It returns nil if rate limiting is disabled.
+func newRateLimiter() (*rateLimiter, error) {
+	if *rateLimit <= 0 {
+		return nil, nil
+	}
+	l := &rateLimiter{
+		rate:    *rateLimit / 60,
+		burst:   math.Max(float64(*rateBurst), 1),
+		buckets: map[string]*bucket{},
+	}
+	var err error
+	l.exempt, err = parsePrefixes(*rateLimitExempt)
+	if err != nil {
+		return nil, fmt.Errorf("bad -rate-limit-exempt: %w", err)
+	}
+	return l, nil
+}
+
+// parsePrefixes parses a comma separated list of IP addresses and
+// CIDR ranges.
+func parsePrefixes(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// containsIP returns true if ip is in any of prefixes
+func containsIP(prefixes []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// allow takes a token for ip returning false and the time until the
+// next token if there are none left.
+func (l *rateLimiter) allow(ip string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if len(l.buckets) >= maxRateBuckets {
+		l.prune(now)
+	}
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune removes the buckets which have refilled - call with mu held
+func (l *rateLimiter) prune(now time.Time) {
+	for ip, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+// wrap next with the rate limiter returning 429 Too Many Requests
+// for clients over the limit.
+//
+// A nil rateLimiter returns next unchanged.
+func (l *rateLimiter) wrap(next http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if !containsIP(l.exempt, ip) {
+			ok, wait := l.allow(ip)
+			if !ok {
+				retryAfter := int(math.Ceil(wait.Seconds()))
+				slog.Warn("Rate limit exceeded", "client", ip, "retry_after", retryAfter)
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				writeErrorCode(w, codeRateLimited, "rate limit exceeded")
+				return
+			}
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// reloadTimeout is how long POST /reload waits for the main page to
+// settle on Google Photos
+const reloadTimeout = 30 * time.Second
+
+// reloadMainPage navigates the authenticated main page back to Google
+// Photos and checks it is still logged in, returning the URL it ends
+// up on.
+func (g *Gphotos) reloadMainPage() (string, error) {
+	// Don't race with a download
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	err := g.wake()
+	if err != nil {
+		return "", err
+	}
+	page := g.mainPage()
+	if page == nil {
+		return "", errors.New("no main page")
+	}
+	err = navigate(page, slog.Default(), *gphotosURL)
+	if err == nil {
+		err = waitPage(page)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to reload main page: %w", err)
+	}
+	url := ""
+	for deadline := time.Now().Add(reloadTimeout); time.Now().Before(deadline); time.Sleep(time.Second) {
+		info, err := page.Info()
+		if err != nil {
+			return "", fmt.Errorf("failed to read main page info: %w", err)
+		}
+		url = info.URL
+		switch loginStage(url) {
+		case stageSignedIn:
+			return url, nil
+		case stageConsentPage:
+			_, err = dismissConsent(page, slog.Default())
+			if err != nil {
+				slog.Warn("Could not dismiss consent page, retrying...", "err", err)
+			}
+		}
+	}
+	return url, withCode(codeNotAuthenticated, fmt.Errorf("main page ended up on %q - browser is not logged in, rerun with the -login flag", url))
+}
+
+// reloadJSON is the JSON returned by POST /reload for each browser
+type reloadJSON struct {
+	Browser       int    `json:"browser"` // index of the -browsers instance
+	Authenticated bool   `json:"authenticated"`
+	URL           string `json:"url,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Reload the main page of each browser and report whether they are
+// still logged in.
+//
+// This needs the -admin-token.
+func (g *Gphotos) postReload(w http.ResponseWriter, r *http.Request) {
+	slog.Info("got reload request", "client", clientIP(r))
+	if !checkAdmin(w, r) {
+		return
+	}
+	status := http.StatusOK
+	results := []reloadJSON{}
+	for i, b := range append([]*Gphotos{g}, g.workers...) {
+		url, err := b.reloadMainPage()
+		result := reloadJSON{Browser: i, Authenticated: err == nil, URL: url}
+		if err != nil {
+			slog.Error("Reload failed", "browser", i, "err", err)
+			result.Error = err.Error()
+			status = http.StatusServiceUnavailable
+		}
+		results = append(results, result)
+	}
+	slog.Info("Reloaded main pages", "ok", status == http.StatusOK)
+	writeJSON(w, status, results)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultRestartTime is the estimate of how long a browser restart
+// takes before one has been timed
+const defaultRestartTime = 30 * time.Second
+
+// restartState tracks whether the browser is being restarted so
+// requests can be turned away rather than queueing behind it.
+type restartState struct {
+	mu       sync.Mutex
+	since    time.Time     // when the restart in progress started, zero if none
+	lastTime time.Duration // how long the last restart took
+}
+
+// begin marks the start of a restart
+func (s *restartState) begin() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.since = time.Now()
+}
+
+// end marks the end of a restart
+func (s *restartState) end() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastTime = time.Since(s.since)
+	s.since = time.Time{}
+}
+
+// retryAfter returns true and the estimated time until the restart
+// finishes if a restart is in progress.
+func (s *restartState) retryAfter() (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.since.IsZero() {
+		return false, 0
+	}
+	expected := s.lastTime
+	if expected == 0 {
+		expected = defaultRestartTime
+	}
+	return true, max(expected-time.Since(s.since), time.Second)
+}
+
+// restartRetryAfter returns true and the estimated time until the
+// restart finishes if the browser which would serve a request now is
+// restarting.
+//
+// With -browsers that is only when they are all restarting as the
+// pool sends requests to the others.
+func (g *Gphotos) restartRetryAfter() (bool, time.Duration) {
+	if g.pool == nil {
+		return g.restart.retryAfter()
+	}
+	return g.pool.workers[g.pool.pick()].restart.retryAfter()
+}
+
+// wrapRestart wraps next returning 503 Service Unavailable with a
+// Retry-After header while the browser which would serve the request
+// is restarting.
+func (g *Gphotos) wrapRestart(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if restarting, wait := g.restartRetryAfter(); restarting {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			slog.Warn("Browser restarting - rejecting request", "path", r.URL.Path, "retry_after", retryAfter)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			writeErrorCode(w, codeBrowserRestarting, "browser restarting")
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRestartStateRetryAfter(t *testing.T) {
+	var s restartState
+	if restarting, _ := s.retryAfter(); restarting {
+		t.Fatal("restarting before begin")
+	}
+	s.begin()
+	restarting, wait := s.retryAfter()
+	if !restarting || wait <= defaultRestartTime-time.Second || wait > defaultRestartTime {
+		t.Errorf("got %v %v, want restarting with about %v to wait", restarting, wait, defaultRestartTime)
+	}
+	s.end()
+	if restarting, _ := s.retryAfter(); restarting {
+		t.Fatal("restarting after end")
+	}
+
+	// The next estimate is from the last restart, but at least 1s
+	s.mu.Lock()
+	s.lastTime = 5 * time.Second
+	s.mu.Unlock()
+	s.begin()
+	if _, wait := s.retryAfter(); wait <= 4*time.Second || wait > 5*time.Second {
+		t.Errorf("wait %v, want about 5s", wait)
+	}
+	s.mu.Lock()
+	s.since = time.Now().Add(-time.Minute)
+	s.mu.Unlock()
+	if _, wait := s.retryAfter(); wait != time.Second {
+		t.Errorf("wait %v for an overdue restart, want 1s", wait)
+	}
+	s.end()
+}
+
+func TestGetIDDuringRestart(t *testing.T) {
+	d := newFakeDownloader(t)
+	g := newTestGphotos(t, d)
+	srv := newTestServer(t, g)
+
+	g.restart.begin()
+	for _, path := range []string{"/id/" + testPhotoID, "/id/" + testPhotoID + "/meta"} {
+		resp, body := getPhoto(t, srv.URL, path)
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("%s: status %d, want 503: %s", path, resp.StatusCode, body)
+		}
+		retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err != nil || retryAfter < 1 || retryAfter > int(defaultRestartTime.Seconds()) {
+			t.Errorf("%s: Retry-After %q, want 1 to %v seconds", path, resp.Header.Get("Retry-After"), defaultRestartTime.Seconds())
+		}
+		if got := string(body); !strings.Contains(got, `"code":"`+codeBrowserRestarting+`"`) {
+			t.Errorf("%s: body %s, want code %s", path, got, codeBrowserRestarting)
+		}
+	}
+	if got := d.calls.Load(); got != 0 {
+		t.Errorf("%d downloads during the restart, want 0", got)
+	}
+
+	// Requests are served again once the restart is over
+	g.restart.end()
+	resp, body := getPhoto(t, srv.URL, "/id/"+testPhotoID)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status %d after restart, want 200: %s", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Retry-After") != "" {
+		t.Error("Retry-After set after restart")
+	}
+}
+
+// With -browsers a restart of one turns requests away only if the
+// others are restarting too
+func TestPoolRestart(t *testing.T) {
+	d := newFakeDownloader(t)
+	g := newTestGphotos(t, d)
+	w := newTestGphotos(t, d)
+	g.pool = &browserPool{
+		workers: []*Gphotos{g, w},
+		busy:    make([]atomic.Int64, 2),
+	}
+	srv := newTestServer(t, g)
+	path := "/id/" + testPhotoID
+
+	w.restart.begin()
+	if got := g.pool.pick(); got != 0 {
+		t.Errorf("picked restarting browser %d", got)
+	}
+	// A busier browser is picked over the restarting one
+	g.pool.busy[0].Add(1)
+	if got := g.pool.pick(); got != 0 {
+		t.Errorf("picked restarting browser %d", got)
+	}
+	g.pool.busy[0].Add(-1)
+	resp, body := getPhoto(t, srv.URL, path)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status %d with one browser restarting, want 200: %s", resp.StatusCode, body)
+	}
+
+	g.restart.begin()
+	resp, body = getPhoto(t, srv.URL, path)
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status %d with all browsers restarting, want 503: %s", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("no Retry-After")
+	}
+	g.restart.end()
+	w.restart.end()
+}
+
+// A browser which fails to restart is started again by the next request
+func TestRestartBrowserFails(t *testing.T) {
+	google := newFakeGoogle(t)
+	g := startTestBrowser(t, google)
+
+	oldPath := browserPath
+	browserPath = filepath.Join(t.TempDir(), "no-such-browser")
+	g.mu.Lock()
+	err := g.restartBrowser("test")
+	g.mu.Unlock()
+	browserPath = oldPath
+	if err == nil {
+		t.Fatal("restart with a missing browser succeeded")
+	}
+	if !g.asleep.Load() {
+		t.Error("browser not asleep after the failed restart")
+	}
+
+	path, err := g.Download(testPhotoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Remove(path)
+	if g.asleep.Load() {
+		t.Error("browser still asleep after a download")
+	}
+}
@@ -0,0 +1,86 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// processTreeRSS returns the resident memory in bytes of the process
+// with pid and all its descendants.
+//
+// The browser runs its renderers and GPU work in child processes so
+// the top process alone would miss most of the memory.
+func processTreeRSS(pid int) (int64, error) {
+	// Find the children of each process
+	dirs, err := filepath.Glob("/proc/[0-9]*")
+	if err != nil {
+		return 0, err
+	}
+	children := map[int][]int{}
+	for _, dir := range dirs {
+		child, err := strconv.Atoi(filepath.Base(dir))
+		if err != nil {
+			continue
+		}
+		ppid, err := parentPID(dir)
+		if err != nil {
+			continue // process has probably exited
+		}
+		children[ppid] = append(children[ppid], child)
+	}
+
+	total, err := processRSS(pid)
+	if err != nil {
+		return 0, err
+	}
+	todo := children[pid]
+	for len(todo) > 0 {
+		p := todo[len(todo)-1]
+		todo = append(todo[:len(todo)-1], children[p]...)
+		rss, err := processRSS(p)
+		if err == nil {
+			total += rss
+		}
+	}
+	return total, nil
+}
+
+// parentPID reads the parent PID from the stat file in the /proc dir
+func parentPID(dir string) (int, error) {
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return 0, err
+	}
+	// The command name in brackets may contain spaces so start after it
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0, fmt.Errorf("bad stat file in %s", dir)
+	}
+	fields := bytes.Fields(stat[i+1:])
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("bad stat file in %s", dir)
+	}
+	return strconv.Atoi(string(fields[1]))
+}
+
+// processRSS returns the resident memory in bytes of the process
+func processRSS(pid int) (int64, error) {
+	statm, err := os.ReadFile(fmt.Sprintf("/proc/%d/statm", pid))
+	if err != nil {
+		return 0, err
+	}
+	fields := bytes.Fields(statm)
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("bad statm file for process %d", pid)
+	}
+	pages, err := strconv.ParseInt(string(fields[1]), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return pages * int64(os.Getpagesize()), nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+// processTreeRSS returns the resident memory in bytes of the process
+// with pid and all its descendants.
+//
+// This is only implemented on Linux.
+func processTreeRSS(pid int) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// saveRequest is the JSON body for POST /id/{photoID}/save
+type saveRequest struct {
+	Dest string `json:"dest"` // destination path, relative to -save-root or absolute within it, or empty for -cas-dir
+}
+
+// saveResponse is the JSON response for POST /id/{photoID}/save
+type saveResponse struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"` // hex SHA-256 of the contents
+}
+
+// savePath returns the absolute path for dest checking it is within
+// the -save-root directory, creating its parent directory if needed.
+//
+// Symlinks in the parent are resolved and checked before any directory
+// is made so they can't be used to create directories outside the
+// root. dest itself must not exist.
+func savePath(dest string) (string, error) {
+	if dest == "" {
+		return "", errors.New("dest is empty")
+	}
+	root, err := filepath.Abs(*saveRoot)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(dest) {
+		dest = filepath.Join(root, dest)
+	}
+	dest = filepath.Clean(dest)
+	if !withinDir(root, dest) || dest == root {
+		return "", fmt.Errorf("dest %q is outside the save root", dest)
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+
+	// Check the part of the parent which exists resolves inside the
+	// root, then make the rest and check again in case it changed
+	dir := filepath.Dir(dest)
+	existing := dir
+	for {
+		_, err = os.Lstat(existing)
+		if err == nil || !errors.Is(err, fs.ErrNotExist) || existing == root {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	if err != nil {
+		return "", err
+	}
+	err = checkRealDir(realRoot, existing, dest)
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(dir, 0777)
+	if err != nil {
+		return "", err
+	}
+	err = checkRealDir(realRoot, dir, dest)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = os.Lstat(dest)
+	if err == nil {
+		return "", fmt.Errorf("dest %q already exists", dest)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	return dest, nil
+}
+
+// checkRealDir checks dir resolves to a directory inside realRoot
+// with symlinks followed, returning an error about dest if not.
+func checkRealDir(realRoot, dir, dest string) error {
+	realDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	if !withinDir(realRoot, realDir) {
+		return fmt.Errorf("dest %q is outside the save root", dest)
+	}
+	return nil
+}
+
+// withinDir returns true if path is dir or inside it
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// moveFile moves src to dst copying it if they are on different
+// filesystems.
+//
+// It never replaces or writes through an existing dst, including a
+// symlink, returning an error wrapping fs.ErrExist instead.
+func moveFile(src, dst string) error {
+	err := os.Link(src, dst)
+	if err == nil {
+		return os.Remove(src)
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	// Hard links fail across filesystems and on some which don't
+	// support them so copy instead
+	slog.Debug("Copying file", "src", src, "dst", dst, "link_err", err)
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// Save a photo ID into -save-root, or with no dest into -cas-dir
+func (g *Gphotos) postSave(w http.ResponseWriter, r *http.Request) {
+	photoID := r.PathValue("photoID")
+	slog := slog.With("id", photoID)
+	slog.Info("got photo save request")
+	if !g.checkPhotoID(w, photoID) || !g.checkShare(w, r, photoID) {
+		return
+	}
+	if *saveRoot == "" && *casDir == "" {
+		writeErrorCode(w, codeForbidden, "saving is disabled - set -save-root or -cas-dir")
+		return
+	}
+
+	var req saveRequest
+	err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&req)
+	if err != nil {
+		writeErrorCode(w, codeBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	cas := req.Dest == "" && *casDir != ""
+	dest := ""
+	if !cas {
+		if *saveRoot == "" {
+			writeErrorCode(w, codeForbidden, "saving to dest is disabled - set -save-root")
+			return
+		}
+		dest, err = savePath(req.Dest)
+		if err != nil {
+			slog.Error("Rejected save path", "dest", req.Dest, "err", err)
+			writeErrorCode(w, codeForbidden, err.Error())
+			return
+		}
+	}
+
+	path, filename, err := g.downloadNamed(photoID, 0)
+	if err != nil {
+		slog.Error("Download image failed", "err", err)
+		writeError(w, err)
+		return
+	}
+
+	sum, err := fileSHA256(path)
+	if err != nil {
+		slog.Error("Failed to hash photo", "path", path, "err", err)
+		_ = os.Remove(path)
+		writeError(w, err)
+		return
+	}
+	if cas {
+		contentType, _ := sniffContentType(path)
+		dest, err = storeCAS(path, sum, casExt(filename, contentType))
+	} else {
+		err = moveFile(path, dest)
+		if errors.Is(err, fs.ErrExist) {
+			err = withCode(codeForbidden, fmt.Errorf("dest %q already exists", dest))
+		}
+	}
+	if err != nil {
+		slog.Error("Failed to save photo", "path", path, "dest", dest, "err", err)
+		_ = os.Remove(path)
+		writeError(w, err)
+		return
+	}
+	fi, err := os.Stat(dest)
+	if err != nil {
+		slog.Error("Failed to stat saved photo", "dest", dest, "err", err)
+		writeError(w, err)
+		return
+	}
+	slog.Info("Saved photo", "dest", dest, "size", fi.Size(), "sha256", sum)
+	w.Header().Set("X-Content-SHA256", sum)
+	writeJSON(w, http.StatusOK, saveResponse{Path: dest, Size: fi.Size(), SHA256: sum})
+}
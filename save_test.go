@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setSaveRoot sets -save-root to a new temporary directory returning
+// it and a directory outside it
+func setSaveRoot(t *testing.T) (root, outside string) {
+	root = t.TempDir()
+	outside = t.TempDir()
+	setFlag(t, "save-root", root)
+	return root, outside
+}
+
+func TestSavePath(t *testing.T) {
+	root, _ := setSaveRoot(t)
+	for _, test := range []struct {
+		dest string
+		want string
+	}{
+		{"a.jpg", filepath.Join(root, "a.jpg")},
+		{"sub/dir/a.jpg", filepath.Join(root, "sub", "dir", "a.jpg")},
+		{filepath.Join(root, "b.jpg"), filepath.Join(root, "b.jpg")},
+		{"sub/../c.jpg", filepath.Join(root, "c.jpg")},
+	} {
+		got, err := savePath(test.dest)
+		if err != nil {
+			t.Errorf("savePath(%q): %v", test.dest, err)
+		} else if got != test.want {
+			t.Errorf("savePath(%q) = %q, want %q", test.dest, got, test.want)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(root, "sub", "dir")); err != nil || !fi.IsDir() {
+		t.Errorf("parent directory not made: %v", err)
+	}
+	for _, dest := range []string{"", ".", "../a.jpg", "/etc/passwd", "sub/../../a.jpg"} {
+		if _, err := savePath(dest); err == nil {
+			t.Errorf("savePath(%q) succeeded, want error", dest)
+		}
+	}
+}
+
+func TestSavePathSymlinkParent(t *testing.T) {
+	root, outside := setSaveRoot(t)
+	err := os.Symlink(outside, filepath.Join(root, "link"))
+	if err != nil {
+		t.Skipf("can't make symlinks: %v", err)
+	}
+	_, err = savePath("link/new/dir/a.jpg")
+	if err == nil || !strings.Contains(err.Error(), "outside the save root") {
+		t.Errorf("got %v, want outside the save root", err)
+	}
+	// Nothing may be made outside the root
+	checkEmptyDir(t, outside)
+}
+
+func TestSavePathExisting(t *testing.T) {
+	root, outside := setSaveRoot(t)
+	target := filepath.Join(outside, "target")
+	err := os.WriteFile(target, []byte("precious"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(root, "file.jpg"), nil, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink(target, filepath.Join(root, "link.jpg"))
+	if err != nil {
+		t.Skipf("can't make symlinks: %v", err)
+	}
+	for _, dest := range []string{"file.jpg", "link.jpg"} {
+		_, err = savePath(dest)
+		if err == nil || !strings.Contains(err.Error(), "already exists") {
+			t.Errorf("savePath(%q) got %v, want already exists", dest, err)
+		}
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	write := func(path, data string) {
+		t.Helper()
+		err := os.WriteFile(path, []byte(data), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(src, "photo")
+	dst := filepath.Join(dir, "dst")
+	err := moveFile(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "photo" {
+		t.Errorf("dst has %q, want photo", data)
+	}
+	if _, err := os.Stat(src); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("src still there: %v", err)
+	}
+
+	// Existing files and symlinks aren't replaced or written through
+	target := filepath.Join(dir, "target")
+	write(target, "precious")
+	link := filepath.Join(dir, "link")
+	err = os.Symlink(target, link)
+	if err != nil {
+		t.Skipf("can't make symlinks: %v", err)
+	}
+	for _, dst := range []string{target, link} {
+		write(src, "photo")
+		err = moveFile(src, dst)
+		if !errors.Is(err, fs.ErrExist) {
+			t.Errorf("moveFile to %q got %v, want exists", dst, err)
+		}
+	}
+	if data, _ := os.ReadFile(target); string(data) != "precious" {
+		t.Errorf("target overwritten with %q", data)
+	}
+}
+
+// postSave saves testPhotoID to dest returning the response
+func postSave(t *testing.T, h http.Handler, dest string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, _ := json.Marshal(saveRequest{Dest: dest})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/id/"+testPhotoID+"/save", bytes.NewReader(body)))
+	return rec
+}
+
+func TestPostSave(t *testing.T) {
+	root, _ := setSaveRoot(t)
+	d := newFakeDownloader(t)
+	g := newTestGphotos(t, d)
+	h := g.handler("")
+
+	rec := postSave(t, h, "album/photo.jpg")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var resp saveResponse
+	err := json.Unmarshal(rec.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(root, "album", "photo.jpg")
+	if resp.Path != want || resp.Size != int64(len(testPhoto)) {
+		t.Errorf("got %+v, want path %q size %d", resp, want, len(testPhoto))
+	}
+	if data, _ := os.ReadFile(want); !bytes.Equal(data, testPhoto) {
+		t.Errorf("saved %q, want %q", data, testPhoto)
+	}
+
+	// Saving again is refused before downloading
+	calls := d.calls.Load()
+	rec = postSave(t, h, "album/photo.jpg")
+	if e := readError(t, rec); e.Code != codeForbidden {
+		t.Errorf("second save got %d %+v, want forbidden", rec.Code, e)
+	}
+	if d.calls.Load() != calls {
+		t.Error("photo downloaded for a refused save")
+	}
+	if files := d.files(t); len(files) != 0 {
+		t.Errorf("files left after saving: %q", files)
+	}
+}
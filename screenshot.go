@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// Screenshot opens the page for photoID and returns a PNG screenshot
+// of what the browser shows, without downloading the photo.
+func (g *Gphotos) Screenshot(photoID string) ([]byte, error) {
+	// Share the browser with the downloads
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	slog := slog.With("id", photoID)
+	err := g.wake()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *downloadTimeout)
+	defer cancel()
+
+	page, err := g.openPhoto(ctx, slog, photoID)
+	if err != nil {
+		return nil, err
+	}
+	defer g.closeTab(page)
+
+	// Take the screenshot anyway as it shows why the photo didn't load
+	err = waitPhotoReady(page, slog)
+	if err != nil {
+		slog.Warn("Photo not ready for screenshot", "err", err)
+	}
+
+	png, err := page.Screenshot(false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to take screenshot: %w", err)
+	}
+	return png, nil
+}
+
+// Serve a screenshot of the browser showing a photo ID - for debugging.
+//
+// This needs -debug or the -admin-token.
+func (g *Gphotos) getScreenshot(w http.ResponseWriter, r *http.Request) {
+	photoID := r.PathValue("photoID")
+	slog.Info("got photo screenshot request", "id", photoID)
+	if !*debug && !checkAdmin(w, r) {
+		return
+	}
+	if !g.checkPhotoID(w, photoID) || !g.checkShare(w, r, photoID) {
+		return
+	}
+	png, err := g.Screenshot(photoID)
+	if err != nil {
+		slog.Error("Screenshot failed", "id", photoID, "err", err)
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Length", strconv.Itoa(len(png)))
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write(png)
+}
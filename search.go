@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// olderStopCount is how many photos in a row older than ?after= the
+// timeline search must see before stopping. The timeline is newest
+// first but the lazy loaded grid may render a few items out of order.
+const olderStopCount = 20
+
+// labelDateRe matches the date and optional time in the label of a
+// photo link, eg "Photo - Landscape - Jan 5, 2024, 3:45:12 PM"
+var labelDateRe = regexp.MustCompile(`([A-Z][a-z]{2,8}\.? \d{1,2}, \d{4})(?:,? (\d{1,2}:\d{2}(?::\d{2})? ?[AP]M))?`)
+
+// labelDateLayouts are the layouts for the date and time matched by
+// labelDateRe
+var labelDateLayouts = []string{
+	"Jan 2, 2006 3:04:05 PM",
+	"Jan 2, 2006 3:04:05PM",
+	"Jan 2, 2006 3:04 PM",
+	"January 2, 2006 3:04:05 PM",
+	"January 2, 2006 3:04 PM",
+	"Jan 2, 2006",
+	"January 2, 2006",
+}
+
+// labelDate returns the date in the label of a photo link in loc
+func labelDate(label string, loc *time.Location) (time.Time, bool) {
+	// The time uses narrow no-break spaces in newer browsers
+	label = strings.NewReplacer("\u202f", " ", "\u00a0", " ").Replace(label)
+	match := labelDateRe.FindStringSubmatch(label)
+	if match == nil {
+		return time.Time{}, false
+	}
+	value := strings.TrimSpace(strings.ReplaceAll(match[1], ".", "") + " " + match[2])
+	for _, layout := range labelDateLayouts {
+		t, err := time.ParseInLocation(layout, value, loc)
+		if err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseSearchDate parses a date as YYYY-MM-DD, the start of that day
+// in loc, or as RFC 3339
+func parseSearchDate(value string, loc *time.Location) (time.Time, error) {
+	t, err := time.ParseInLocation(time.DateOnly, value, loc)
+	if err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// Serve the photo IDs taken in a date range from the library
+// timeline.
+//
+// ?after= is inclusive and ?before= exclusive. The IDs are streamed as
+// JSON a page at a time like getAlbum.
+func (g *Gphotos) getSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	slog := slog.With("after", q.Get("after"), "before", q.Get("before"))
+	slog.Info("got search request")
+
+	loc := time.Local
+	if tz := q.Get("tz"); tz != "" {
+		var err error
+		loc, err = time.LoadLocation(tz)
+		if err != nil {
+			writeErrorCode(w, codeBadRequest, fmt.Sprintf("bad tz %q: %v", tz, err))
+			return
+		}
+	}
+	var after, before time.Time
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"after", &after}, {"before", &before}} {
+		value := q.Get(p.name)
+		if value == "" {
+			continue
+		}
+		var err error
+		*p.t, err = parseSearchDate(value, loc)
+		if err != nil {
+			writeErrorCode(w, codeBadRequest, fmt.Sprintf("bad %s %q - expecting YYYY-MM-DD or RFC 3339", p.name, value))
+			return
+		}
+	}
+	if after.IsZero() && before.IsZero() {
+		writeErrorCode(w, codeBadRequest, "need after or before")
+		return
+	}
+	if !after.IsZero() && !before.IsZero() && !before.After(after) {
+		writeErrorCode(w, codeBadRequest, "before must be later than after")
+		return
+	}
+	start, limit := 0, defaultAlbumLimit
+	var err error
+	if cursor := q.Get("cursor"); cursor != "" {
+		start, err = strconv.Atoi(cursor)
+		if err != nil || start < 0 {
+			writeErrorCode(w, codeBadRequest, "invalid cursor")
+			return
+		}
+	}
+	if s := q.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			writeErrorCode(w, codeBadRequest, "invalid limit")
+			return
+		}
+	}
+
+	// Share the browser with the downloads
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	err = g.wake()
+	if err != nil {
+		slog.Error("Failed to start browser", "err", err)
+		writeError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), *downloadTimeout)
+	defer cancel()
+
+	page, err := g.newTab(ctx)
+	if err != nil {
+		slog.Error("Failed to open browser tab", "err", err)
+		writeError(w, err)
+		return
+	}
+	defer g.closeTab(page)
+
+	slog.Debug("Navigate to timeline")
+	err = navigate(page, slog, *gphotosURL)
+	if err == nil {
+		err = waitPage(page)
+	}
+	if err != nil {
+		slog.Error("Failed to load timeline", "err", err)
+		writeError(w, err)
+		return
+	}
+
+	// Stream the IDs in range as we find them
+	s := &idStream{w: w}
+	s.start()
+	i, older, scanned := 0, 0, 0
+	err = scrollPhotoLinks(page, slog, 0, func(photoID, label string) bool {
+		scanned++
+		taken, ok := labelDate(label, loc)
+		if !ok {
+			slog.Debug("No date in photo label - skipping", "id", photoID, "label", label)
+			return true
+		}
+		if !after.IsZero() && taken.Before(after) {
+			older++
+			return older < olderStopCount
+		}
+		older = 0
+		if !before.IsZero() && !taken.Before(before) {
+			return true
+		}
+		if i >= start && i < start+limit {
+			s.write(photoID)
+		}
+		i++
+		// Read one past the page to see if there is another page
+		return i <= start+limit && i < *maxSearchItems
+	})
+	if errors.Is(err, context.Canceled) {
+		err = nil
+	} else if err != nil {
+		// Headers are already sent so the error goes at the end
+		slog.Error("Failed to search timeline", "err", err)
+	}
+	next := ""
+	if i > start+limit {
+		next = strconv.Itoa(start + limit)
+	}
+	truncated := next == "" && i >= *maxSearchItems
+	s.end(next, truncated, err)
+	slog.Info("Searched timeline", "cursor", start, "items", s.n, "scanned", scanned, "next", next, "truncated", truncated)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// selftestJSON is the JSON response for GET /selftest
+type selftestJSON struct {
+	OK       bool    `json:"ok"`
+	ID       string  `json:"id"`
+	Duration float64 `json:"duration"` // seconds
+	Size     int64   `json:"size,omitempty"`
+	Filename string  `json:"filename,omitempty"`
+	Code     string  `json:"code,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Download the -selftest-id photo through the full download path and
+// report how it went.
+//
+// This needs -debug or the -admin-token.
+func (g *Gphotos) getSelftest(w http.ResponseWriter, r *http.Request) {
+	slog.Info("got selftest request", "id", *selftestID)
+	if !*debug && !checkAdmin(w, r) {
+		return
+	}
+	start := time.Now()
+	path, filename, err := g.downloadNamed(*selftestID, 0)
+	res := selftestJSON{
+		ID:       *selftestID,
+		Duration: time.Since(start).Seconds(),
+		Filename: filename,
+	}
+	if err != nil {
+		slog.Error("Selftest failed", "id", *selftestID, "err", err)
+		res.Code = errorCode(err)
+		res.Error = err.Error()
+		writeJSON(w, http.StatusServiceUnavailable, res)
+		return
+	}
+	defer removeDownload(slog.With("id", *selftestID), *selftestID, path)
+	fi, err := os.Stat(path)
+	if err == nil {
+		res.Size = fi.Size()
+	}
+	res.OK = true
+	slog.Info("Selftest passed", "id", *selftestID, "duration", res.Duration, "size", res.Size)
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// maxShares is the number of shared photo references remembered
+// before the cache is cleared
+const maxShares = 100000
+
+// shareKeyRe matches a valid shared album ID or key
+var shareKeyRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// shareRef locates a photo in a shared album or the Locked Folder
+// which the direct photo URL doesn't work for
+type shareRef struct {
+	shareID string // ID of the shared album
+	key     string // access key from the share link
+	locked  bool   // set if the photo is in the Locked Folder
+}
+
+// shareCache remembers the shared album each photo was requested from
+type shareCache struct {
+	mu   sync.Mutex
+	refs map[string]shareRef // photo ID to shared album
+}
+
+// get returns the shared album for photoID if known
+func (c *shareCache) get(photoID string) (shareRef, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ref, ok := c.refs[photoID]
+	return ref, ok
+}
+
+// set remembers the shared album for photoID
+func (c *shareCache) set(photoID string, ref shareRef) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refs == nil || len(c.refs) >= maxShares {
+		c.refs = map[string]shareRef{}
+	}
+	c.refs[photoID] = ref
+}
+
+// photoPageURL returns the URL of the page for photoID and where it
+// is if it isn't in the library, or nil if it is.
+func (g *Gphotos) photoPageURL(photoID string) (string, *shareRef) {
+	ref, ok := g.shares.get(photoID)
+	if !ok {
+		return *gphotoURL + photoID, nil
+	}
+	if ref.locked {
+		return *lockedURL + "photo/" + photoID, &ref
+	}
+	u := *sharedURL + ref.shareID + "/photo/" + photoID
+	if ref.key != "" {
+		u += "?key=" + url.QueryEscape(ref.key)
+	}
+	return u, &ref
+}
+
+// checkShare reads the optional share and key parameters of r which
+// say photoID is in a shared album, or the locked parameter which says
+// it is in the Locked Folder, writing a 400 error and returning false
+// if they are malformed.
+func (g *Gphotos) checkShare(w http.ResponseWriter, r *http.Request, photoID string) bool {
+	q := r.URL.Query()
+	if locked, _ := strconv.ParseBool(q.Get("locked")); locked {
+		g.shares.set(photoID, shareRef{locked: true})
+		return true
+	}
+	shareID, key := q.Get("share"), q.Get("key")
+	if shareID == "" && key == "" {
+		return true
+	}
+	if !shareKeyRe.MatchString(shareID) || (key != "" && !shareKeyRe.MatchString(key)) {
+		slog.Warn("Malformed share", "id", photoID, "share", shareID)
+		writeErrorCode(w, codeBadRequest, fmt.Sprintf("malformed share %q or key - expecting 1 to 256 characters from A-Z, a-z, 0-9, - and _", shareID))
+		return false
+	}
+	g.shares.set(photoID, shareRef{shareID: shareID, key: key})
+	return true
+}
@@ -7,3 +7,5 @@ import (
 )
 
 var exitSignals = []os.Signal{os.Interrupt}
+
+var reloadSignals = []os.Signal{} // No SIGHUP here
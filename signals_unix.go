@@ -8,3 +8,5 @@ import (
 )
 
 var exitSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM} // Not syscall.SIGQUIT as we want the default behaviour
+
+var reloadSignals = []os.Signal{syscall.SIGHUP} // Signals to reload config on
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// imageCacheTime is how long the image found on a photo page is
+// remembered for /thumb and /srcset
+const imageCacheTime = 10 * time.Minute
+
+// srcsetWidths are the widths in pixels listed by /srcset
+var srcsetWidths = []int{256, 512, 1024, 1600, 2048, 3200}
+
+// imageCache remembers the image shown on each photo page for a while
+type imageCache struct {
+	mu     sync.Mutex
+	images map[string]imageEntry // photo ID to image
+}
+
+// imageEntry is an image in the imageCache
+type imageEntry struct {
+	image   pageImage
+	expires time.Time
+}
+
+// get returns the image for photoID if known and not expired
+func (c *imageCache) get(photoID string) (pageImage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.images[photoID]
+	if !ok || time.Now().After(entry.expires) {
+		return pageImage{}, false
+	}
+	return entry.image, true
+}
+
+// set remembers the image for photoID, dropping any expired entries
+func (c *imageCache) set(photoID string, image pageImage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.images == nil {
+		c.images = map[string]imageEntry{}
+	}
+	for id, entry := range c.images {
+		if now.After(entry.expires) {
+			delete(c.images, id)
+		}
+	}
+	c.images[photoID] = imageEntry{image: image, expires: now.Add(imageCacheTime)}
+}
+
+// srcsetSource is an image URL at one width for GET /srcset
+type srcsetSource struct {
+	Width int    `json:"width"`
+	URL   string `json:"url"`
+}
+
+// srcsetJSON is the JSON for GET /srcset
+type srcsetJSON struct {
+	ID      string         `json:"id"`
+	Video   bool           `json:"video"` // the sources are posters of the video
+	Sources []srcsetSource `json:"sources"`
+	Srcset  string         `json:"srcset"` // for the srcset attribute of an img
+}
+
+// Serve the image of a photo ID at a range of widths for responsive
+// clients, as JSON or with ?format=text as an HTML srcset attribute.
+func (g *Gphotos) getSrcset(w http.ResponseWriter, r *http.Request) {
+	photoID := r.PathValue("photoID")
+	slog.Info("got photo srcset request", "id", photoID)
+	if !g.checkPhotoID(w, photoID) || !g.checkShare(w, r, photoID) {
+		return
+	}
+	img, err := g.PageImage(photoID)
+	if err != nil {
+		slog.Error("Srcset failed", "id", photoID, "err", err)
+		writeError(w, err)
+		return
+	}
+	out := srcsetJSON{ID: photoID, Video: img.Video}
+	var srcset []string
+	for _, width := range srcsetWidths {
+		u := setImageParam(img.Src, "w"+strconv.Itoa(width))
+		out.Sources = append(out.Sources, srcsetSource{Width: width, URL: u})
+		srcset = append(srcset, fmt.Sprintf("%s %dw", u, width))
+	}
+	out.Srcset = strings.Join(srcset, ", ")
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(w, out.Srcset)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
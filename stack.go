@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// findElements returns the elements matching selector on page which
+// is XPath if it starts with / or ( and CSS otherwise.
+//
+// Unlike findElement it doesn't wait for them to appear.
+func findElements(page *rod.Page, selector string) (rod.Elements, error) {
+	if strings.HasPrefix(selector, "/") || strings.HasPrefix(selector, "(") {
+		return page.ElementsX(selector)
+	}
+	return page.Elements(selector)
+}
+
+// stackFrames returns the frames of the burst or stack shown on page,
+// which is empty if it is a single photo
+func stackFrames(page *rod.Page) (rod.Elements, error) {
+	frames, err := findElements(page, *stackSelector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find stack frames - set -stack-selector to match them: %w", err)
+	}
+	if len(frames) <= 1 {
+		return nil, nil
+	}
+	return frames, nil
+}
+
+// selectFrame shows frame, numbered from 1, of the burst or stack
+// photoID on page so the download key downloads it
+func selectFrame(page *rod.Page, slog *slog.Logger, photoID string, frame int) error {
+	frames, err := stackFrames(page)
+	if err != nil {
+		return err
+	}
+	if len(frames) == 0 {
+		if frame == 1 {
+			// A single photo is its own first frame
+			return nil
+		}
+		return fmt.Errorf("photo %q has no frame %d as it isn't a burst or stack: %w", photoID, frame, httpError(http.StatusNotFound))
+	}
+	if frame > len(frames) {
+		return fmt.Errorf("photo %q has no frame %d as it only has %d: %w", photoID, frame, len(frames), httpError(http.StatusNotFound))
+	}
+	slog.Debug("Select stack frame", "frame", frame, "frames", len(frames))
+	err = frames[frame-1].Click(proto.InputMouseButtonLeft, 1)
+	if err != nil {
+		return fmt.Errorf("failed to select frame %d: %w", frame, err)
+	}
+	err = page.WaitStable(time.Second)
+	if err != nil {
+		return fmt.Errorf("page load after selecting frame %d: %w", frame, err)
+	}
+	return waitPhotoReady(page, slog)
+}
+
+// StackFrames opens the page for photoID and returns the number of
+// frames in it if it is a burst or stack, or 0 if it is a single
+// photo, without downloading it.
+func (g *Gphotos) StackFrames(photoID string) (int, error) {
+	// Share the browser with the downloads
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	slog := slog.With("id", photoID)
+	err := g.wake()
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *downloadTimeout)
+	defer cancel()
+
+	page, err := g.openPhoto(ctx, slog, photoID)
+	if err != nil {
+		return 0, err
+	}
+	defer g.closeTab(page)
+
+	err = waitPhotoReady(page, slog)
+	if err != nil {
+		return 0, err
+	}
+	frames, err := stackFrames(page)
+	if err != nil {
+		return 0, err
+	}
+	slog.Debug("Counted stack frames", "frames", len(frames))
+	return len(frames), nil
+}
+
+// checkFrame reads the frame parameter of r writing a 400 error and
+// returning false if it is invalid. The frame is 0 if not given.
+func checkFrame(w http.ResponseWriter, r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("frame")
+	if value == "" {
+		return 0, true
+	}
+	frame, err := strconv.Atoi(value)
+	if err != nil || frame < 1 {
+		writeErrorCode(w, codeBadRequest, fmt.Sprintf("invalid frame %q - expecting a number from 1", value))
+		return 0, false
+	}
+	return frame, true
+}
+
+// Serve whether a photo ID is a burst or stack and how many frames it
+// has
+func (g *Gphotos) getFrames(w http.ResponseWriter, r *http.Request) {
+	photoID := r.PathValue("photoID")
+	slog.Info("got photo frames request", "id", photoID)
+	if !g.checkPhotoID(w, photoID) || !g.checkShare(w, r, photoID) {
+		return
+	}
+	frames, err := g.StackFrames(photoID)
+	if err != nil {
+		slog.Error("Reading stack frames failed", "id", photoID, "err", err)
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":     photoID,
+		"stack":  frames > 0,
+		"frames": frames,
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pidFile is the name of the file in the download directory holding
+// the PID of the process using it
+const pidFile = program + ".pid"
+
+// writePIDFile marks dir as in use by this process
+func writePIDFile(dir string) error {
+	return os.WriteFile(filepath.Join(dir, pidFile), []byte(strconv.Itoa(os.Getpid())), 0600)
+}
+
+// dirInUse returns true if dir belongs to a running process
+func dirInUse(dir string) bool {
+	data, err := os.ReadFile(filepath.Join(dir, pidFile))
+	if err != nil {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return false
+	}
+	return pid == os.Getpid() || processAlive(pid)
+}
+
+// cleanStale removes download directories left behind by previous
+// runs which crashed.
+//
+// Only directories older than -clean-stale-age whose process is no
+// longer running are removed so concurrent instances are safe.
+func cleanStale() error {
+	tmp := os.TempDir()
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to read temp directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), program) {
+			continue
+		}
+		dir := filepath.Join(tmp, entry.Name())
+		if dir == downloadDir {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil || time.Since(fi.ModTime()) < *cleanStaleAge {
+			continue
+		}
+		if dirInUse(dir) {
+			slog.Debug("Not removing download directory in use", "dir", dir)
+			continue
+		}
+		err = os.RemoveAll(dir)
+		if err != nil {
+			slog.Error("Failed to remove stale download directory", "dir", dir, "err", err)
+			continue
+		}
+		slog.Info("Removed stale download directory", "dir", dir, "age", time.Since(fi.ModTime()).Round(time.Second))
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+)
+
+// staticFS holds the favicon, stylesheet and -ui script for the web
+// pages
+//
+//go:embed static
+var staticFS embed.FS
+
+// Serve the favicon
+func getFavicon(w http.ResponseWriter, r *http.Request) {
+	http.ServeFileFS(w, r, staticFS, "static/favicon.ico")
+}
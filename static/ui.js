@@ -0,0 +1,46 @@
+// Download form for the gphotosdl root page with -ui
+"use strict";
+
+// parsePhoto returns the download path for a Google Photos URL or a
+// bare photo ID, or null if it isn't one
+function parsePhoto(input) {
+  input = input.trim();
+  if (/^[A-Za-z0-9_-]{16,128}$/.test(input)) {
+    return "/id/" + input;
+  }
+  let url;
+  try {
+    url = new URL(input);
+  } catch (e) {
+    return null;
+  }
+  const match = url.pathname.match(/\/photo\/([A-Za-z0-9_-]+)/);
+  if (!match) {
+    return null;
+  }
+  const params = new URLSearchParams();
+  const share = url.pathname.match(/\/share\/([A-Za-z0-9_-]+)\//);
+  if (share) {
+    params.set("share", share[1]);
+    if (url.searchParams.get("key")) {
+      params.set("key", url.searchParams.get("key"));
+    }
+  }
+  if (url.pathname.includes("/lockedfolder/")) {
+    params.set("locked", "true");
+  }
+  params.set("disposition", "attachment");
+  return "/id/" + match[1] + "?" + params.toString();
+}
+
+document.getElementById("download-form").addEventListener("submit", (event) => {
+  event.preventDefault();
+  const status = document.getElementById("download-status");
+  const path = parsePhoto(document.getElementById("photo").value);
+  if (!path) {
+    status.textContent = "That isn't a Google Photos photo URL or ID.";
+    return;
+  }
+  status.textContent = "Downloading - this may take a while for large videos.";
+  window.location.href = path;
+});
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// stats are the download counters since start or the last reset
+type stats struct {
+	started atomic.Int64 // time counters were last reset in unix nanoseconds
+	queued  atomic.Int64 // downloads waiting for the browser
+
+	mu            sync.Mutex
+	downloads     int64         // successful downloads
+	failures      map[int]int64 // failed downloads by HTTP status
+	totalDuration time.Duration // total duration of all downloads
+	lastDuration  time.Duration // duration of the last download
+}
+
+// statsJSON is the JSON for GET /stats
+type statsJSON struct {
+	Downloads       int64            `json:"downloads"`
+	Failures        map[string]int64 `json:"failures"`
+	AverageDuration float64          `json:"average_duration"` // seconds
+	LastDuration    float64          `json:"last_duration"`    // seconds
+	Queue           int64            `json:"queue"`
+	Uptime          float64          `json:"uptime"`            // seconds
+	Breaker         *breakerJSON     `json:"breaker,omitempty"` // circuit breaker, if enabled
+}
+
+// reset the counters
+func (s *stats) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started.Store(time.Now().UnixNano())
+	s.downloads = 0
+	s.failures = map[int]int64{}
+	s.totalDuration = 0
+	s.lastDuration = 0
+}
+
+// record the result of a download taking duration
+func (s *stats) record(duration time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastDuration = duration
+	s.totalDuration += duration
+	if err == nil {
+		s.downloads++
+		return
+	}
+	if s.failures == nil {
+		s.failures = map[int]int64{}
+	}
+	s.failures[errorStatus(err)]++
+}
+
+// snapshot returns the counters as JSON
+func (s *stats) snapshot() statsJSON {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := statsJSON{
+		Downloads:    s.downloads,
+		Failures:     map[string]int64{},
+		LastDuration: s.lastDuration.Seconds(),
+		Queue:        s.queued.Load(),
+		Uptime:       time.Since(time.Unix(0, s.started.Load())).Seconds(),
+	}
+	total := s.downloads
+	for status, n := range s.failures {
+		out.Failures[strconv.Itoa(status)] = n
+		total += n
+	}
+	if total > 0 {
+		out.AverageDuration = s.totalDuration.Seconds() / float64(total)
+	}
+	return out
+}
+
+// Serve the download statistics. Pass ?reset=true with the admin
+// token to reset them.
+func (g *Gphotos) getStats(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("got stats request")
+	if r.URL.Query().Get("reset") == "true" {
+		if !checkAdmin(w, r) {
+			return
+		}
+		out := g.statsSnapshot()
+		g.stats.reset()
+		slog.Info("Reset stats")
+		writeJSON(w, http.StatusOK, out)
+		return
+	}
+	writeJSON(w, http.StatusOK, g.statsSnapshot())
+}
+
+// statsSnapshot returns the counters with the circuit breaker state
+func (g *Gphotos) statsSnapshot() statsJSON {
+	out := g.stats.snapshot()
+	if g.breaker != nil {
+		out.Breaker = g.breaker.snapshot()
+	}
+	return out
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// tabPool is a pool of pre-warmed blank tabs
+type tabPool struct {
+	tabs chan *rod.Page
+}
+
+// newTabPool makes a pool holding up to size tabs.
+//
+// It returns nil if size is 0 or less meaning tabs aren't pooled.
+func newTabPool(size int) *tabPool {
+	if size <= 0 {
+		return nil
+	}
+	return &tabPool{
+		tabs: make(chan *rod.Page, size),
+	}
+}
+
+// fill the pool with new tabs
+func (g *Gphotos) fillTabPool() error {
+	if g.tabs == nil {
+		return nil
+	}
+	for len(g.tabs.tabs) < cap(g.tabs.tabs) {
+		tab, err := g.createTab()
+		if err != nil {
+			return fmt.Errorf("failed to pre-warm tab: %w", err)
+		}
+		g.tabs.tabs <- tab
+	}
+	slog.Debug("Pre-warmed tabs", "tabs", len(g.tabs.tabs))
+	return nil
+}
+
+// drainTabPool closes all the tabs in the pool
+func (g *Gphotos) drainTabPool() {
+	if g.tabs == nil {
+		return
+	}
+	for {
+		select {
+		case tab := <-g.tabs.tabs:
+			_ = tab.Close()
+		default:
+			return
+		}
+	}
+}
+
+// createTab opens a new blank tab with the window size set from the
+// flags.
+func (g *Gphotos) createTab() (*rod.Page, error) {
+	tab, err := g.browser.Page(proto.TargetCreateTarget{URL: "about:blank"})
+	if err != nil {
+		return nil, err
+	}
+	err = g.setupTab(tab)
+	if err != nil {
+		_ = tab.Close()
+		return nil, err
+	}
+	return tab, nil
+}
+
+// setupTab applies the window size and user agent to tab
+func (g *Gphotos) setupTab(tab *rod.Page) error {
+	err := proto.EmulationSetDeviceMetricsOverride{
+		Width:             *windowWidth,
+		Height:            *windowHeight,
+		DeviceScaleFactor: 1,
+	}.Call(tab)
+	if err != nil {
+		return fmt.Errorf("failed to set window size: %w", err)
+	}
+	err = tab.SetUserAgent(&proto.NetworkSetUserAgentOverride{
+		UserAgent:      g.userAgent,
+		AcceptLanguage: acceptLanguage(*locale),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set user agent: %w", err)
+	}
+	if *locale != "" {
+		err = proto.EmulationSetLocaleOverride{Locale: strings.ReplaceAll(*locale, "-", "_")}.Call(tab)
+		if err != nil {
+			return fmt.Errorf("failed to set locale: %w", err)
+		}
+	}
+	return nil
+}
+
+// localeRe matches a BCP 47 language tag like en or en-US
+var localeRe = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// acceptLanguage returns the Accept-Language header for locale,
+// preferring it then its base language, eg "en-US,en;q=0.9", or ""
+// for the browser's default
+func acceptLanguage(locale string) string {
+	base, _, hasRegion := strings.Cut(locale, "-")
+	if !hasRegion {
+		return locale
+	}
+	return locale + "," + base + ";q=0.9"
+}
+
+// setUserAgent sets the user agent for the tabs from -user-agent, or
+// the browser's own user agent with the headless marker removed so we
+// look like a desktop Chrome.
+func (g *Gphotos) setUserAgent() error {
+	if *userAgent != "" {
+		g.userAgent = *userAgent
+	} else {
+		v, err := g.browser.Version()
+		if err != nil {
+			return fmt.Errorf("failed to read browser version: %w", err)
+		}
+		g.userAgent = strings.ReplaceAll(v.UserAgent, "HeadlessChrome", "Chrome")
+	}
+	slog.Debug("Using user agent", "user_agent", g.userAgent)
+	return nil
+}
+
+// setDownloadBehavior tells the browser to save downloads into the
+// download directory under their GUIDs without asking.
+//
+// The preferences only set the directory and some Chrome versions and
+// policies still prompt to "allow multiple downloads" or block them,
+// which would leave WaitDownload hanging. WaitDownload puts back this
+// behavior rather than the browser default when it finishes.
+func (g *Gphotos) setDownloadBehavior() error {
+	err := proto.BrowserSetDownloadBehavior{
+		Behavior:     proto.BrowserSetDownloadBehaviorBehaviorAllowAndName,
+		DownloadPath: g.downloadDir,
+	}.Call(g.browser)
+	if err != nil {
+		return fmt.Errorf("failed to allow downloads: %w", err)
+	}
+	slog.Debug("Allowed downloads", "dir", g.downloadDir)
+	return nil
+}
+
+// newTab returns a blank tab bound to ctx, from the pool if possible.
+//
+// The tab should be closed with closeTab after use.
+func (g *Gphotos) newTab(ctx context.Context) (*rod.Page, error) {
+	if g.tabs != nil {
+		select {
+		case tab := <-g.tabs.tabs:
+			return tab.Context(ctx), nil
+		default:
+			slog.Debug("Tab pool empty - opening new tab")
+		}
+	}
+	tab, err := g.createTab()
+	if err != nil {
+		return nil, err
+	}
+	return tab.Context(ctx), nil
+}
+
+// closeTab closes the tab, which works even if its context has
+// expired, and pre-warms a new one in the background to take its
+// place in the pool.
+//
+// Tabs aren't reused as they keep the history, session storage and
+// scripts of the pages they showed.
+func (g *Gphotos) closeTab(page *rod.Page) {
+	err := page.Context(context.Background()).Close()
+	if err != nil {
+		slog.Debug("Failed to close tab", "err", err)
+	}
+	if g.tabs != nil {
+		go g.refillTabPool()
+	}
+}
+
+// refillTabPool adds a new tab to the pool if there is room
+func (g *Gphotos) refillTabPool() {
+	// Stop the browser being restarted or closed under us
+	if !g.rlockAwake() {
+		return
+	}
+	defer g.life.RUnlock()
+	if len(g.tabs.tabs) >= cap(g.tabs.tabs) {
+		return
+	}
+	tab, err := g.createTab()
+	if err != nil {
+		slog.Debug("Failed to pre-warm tab", "err", err)
+		return
+	}
+	select {
+	case g.tabs.tabs <- tab:
+	default:
+		_ = tab.Close()
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// poolTabs returns the target IDs of the tabs in the pool
+func poolTabs(g *Gphotos) map[proto.TargetTargetID]bool {
+	ids := map[proto.TargetTargetID]bool{}
+	for i := len(g.tabs.tabs); i > 0; i-- {
+		tab := <-g.tabs.tabs
+		ids[tab.TargetID] = true
+		g.tabs.tabs <- tab
+	}
+	return ids
+}
+
+func TestTabPoolDoesntReuseTabs(t *testing.T) {
+	google := newFakeGoogle(t)
+	g := startTestBrowser(t, google)
+	g.tabs = newTabPool(2)
+	err := g.fillTabPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := poolTabs(g)
+
+	path, err := g.Download(testPhotoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Remove(path)
+
+	// Wait for the pool to be refilled in the background
+	deadline := time.Now().Add(10 * time.Second)
+	for len(g.tabs.tabs) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	g.life.Lock()
+	after := poolTabs(g)
+	g.life.Unlock()
+	if len(after) != 2 {
+		t.Fatalf("%d tabs in the pool, want 2", len(after))
+	}
+	var used []proto.TargetTargetID
+	for id := range before {
+		if !after[id] {
+			used = append(used, id)
+		}
+	}
+	if len(used) != 1 {
+		t.Fatalf("pool went from %v to %v, want one tab replaced", before, after)
+	}
+
+	// The tab used for the download must be closed
+	res, err := proto.TargetGetTargets{}.Call(g.browser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range res.TargetInfos {
+		if target.TargetID == used[0] {
+			t.Errorf("used tab %s still open at %q", used[0], target.URL)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultThumbSize is the longest side of a thumbnail in pixels
+	// if ?size= isn't given
+	defaultThumbSize = 512
+	// maxThumbSize is the largest ?size= accepted
+	maxThumbSize = 4096
+)
+
+// thumbURLJS returns the src of the largest googleusercontent.com
+// image on the page which is the photo being shown, or the poster of
+// the video, and whether it is a video
+const thumbURLJS = `() => {
+	const video = document.querySelector('video[poster*="googleusercontent.com"]');
+	let best = null;
+	for (const img of document.querySelectorAll('img[src*="googleusercontent.com"]')) {
+		if (!best || img.naturalWidth * img.naturalHeight > best.naturalWidth * best.naturalHeight) {
+			best = img;
+		}
+	}
+	return {
+		src: best ? best.src : (video ? video.poster : ""),
+		video: !!document.querySelector("video"),
+	};
+}`
+
+// pageImage is the image shown on a photo page
+type pageImage struct {
+	Src   string `json:"src"`   // googleusercontent.com URL of the image
+	Video bool   `json:"video"` // set if the image is the poster of a video
+}
+
+// thumbSizeRe matches the size parameters at the end of a
+// googleusercontent.com image URL, eg =w1024-h768-no
+var thumbSizeRe = regexp.MustCompile(`=[a-z0-9-]*$`)
+
+// resizeThumbURL returns the image URL u set to size pixels on the
+// longest side
+func resizeThumbURL(u string, size int) string {
+	return setImageParam(u, "s"+strconv.Itoa(size))
+}
+
+// setImageParam replaces the size parameters of the image URL u with
+// param
+func setImageParam(u, param string) string {
+	if thumbSizeRe.MatchString(u) {
+		return thumbSizeRe.ReplaceAllString(u, "="+param)
+	}
+	return u + "=" + param
+}
+
+// PageImage opens the page for photoID and returns the image shown
+// on it, without downloading the photo.
+//
+// The result is cached for a while as the images are served from
+// stable URLs.
+func (g *Gphotos) PageImage(photoID string) (pageImage, error) {
+	if img, ok := g.images.get(photoID); ok {
+		return img, nil
+	}
+	slog := slog.With("id", photoID)
+	unlock, err := g.lockMeta()
+	if err != nil {
+		return pageImage{}, err
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *downloadTimeout)
+	defer cancel()
+
+	page, err := g.openPhoto(ctx, slog, photoID)
+	if err != nil {
+		return pageImage{}, err
+	}
+	defer g.closeTab(page)
+
+	err = waitPhotoReady(page, slog)
+	if err != nil {
+		return pageImage{}, err
+	}
+	res, err := page.Eval(thumbURLJS)
+	if err != nil {
+		return pageImage{}, fmt.Errorf("failed to read image URL: %w", err)
+	}
+	var img pageImage
+	err = res.Value.Unmarshal(&img)
+	if err != nil {
+		return pageImage{}, fmt.Errorf("failed to decode image URL: %w", err)
+	}
+	if img.Src == "" {
+		return pageImage{}, errors.New("no image found on the photo page")
+	}
+	slog.Debug("Found page image", "url", img.Src, "video", img.Video)
+	g.images.set(photoID, img)
+	return img, nil
+}
+
+// ThumbnailURL returns the URL of the image of photoID at size pixels
+// on the longest side, without downloading the photo.
+func (g *Gphotos) ThumbnailURL(photoID string, size int) (string, error) {
+	img, err := g.PageImage(photoID)
+	if err != nil {
+		return "", err
+	}
+	return resizeThumbURL(img.Src, size), nil
+}
+
+// Serve the thumbnail of a photo ID at ?size= pixels on the longest
+// side, proxied or with ?redirect=true redirected to.
+func (g *Gphotos) getThumb(w http.ResponseWriter, r *http.Request) {
+	photoID := r.PathValue("photoID")
+	slog.Info("got photo thumbnail request", "id", photoID)
+	if !g.checkPhotoID(w, photoID) || !g.checkShare(w, r, photoID) {
+		return
+	}
+	size := defaultThumbSize
+	if s := r.URL.Query().Get("size"); s != "" {
+		var err error
+		size, err = strconv.Atoi(s)
+		if err != nil || size < 1 || size > maxThumbSize {
+			writeErrorCode(w, codeBadRequest, fmt.Sprintf("bad size %q - expecting 1 to %d", s, maxThumbSize))
+			return
+		}
+	}
+	u, err := g.ThumbnailURL(photoID, size)
+	if err != nil {
+		slog.Error("Thumbnail failed", "id", photoID, "err", err)
+		writeError(w, err)
+		return
+	}
+	if r.URL.Query().Get("redirect") == "true" {
+		http.Redirect(w, r, u, http.StatusFound)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, u, nil)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if g.userAgent != "" {
+		req.Header.Set("User-Agent", g.userAgent)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		slog.Error("Thumbnail fetch failed", "id", photoID, "err", err)
+		writeError(w, fmt.Errorf("failed to fetch thumbnail: %w", err))
+		return
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+		slog.Error("Thumbnail fetch failed", "id", photoID, "status", resp.Status, "content_type", resp.Header.Get("Content-Type"))
+		writeError(w, fmt.Errorf("failed to fetch thumbnail: %s", resp.Status))
+		return
+	}
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	if resp.ContentLength >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	_, err = io.Copy(w, resp.Body)
+	if err != nil {
+		slog.Error("Thumbnail copy failed", "id", photoID, "err", err)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"mime"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// integrityRef is what the server said the download should be, for
+// -verify
+type integrityRef struct {
+	size   int64  // Content-Length, -1 if not sent
+	md5    []byte // MD5 from x-goog-hash or Content-MD5, nil if not sent
+	crc32c []byte // big endian CRC32C from x-goog-hash, nil if not sent
+}
+
+// isDownloadResponse returns true if the response headers are for a
+// file the browser will save rather than show
+func isDownloadResponse(headers proto.NetworkHeaders) bool {
+	disposition, _, err := mime.ParseMediaType(headerValue(headers, "Content-Disposition"))
+	return err == nil && disposition == "attachment"
+}
+
+// headerValue returns the value of the header name in headers
+func headerValue(headers proto.NetworkHeaders, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v.Str()
+		}
+	}
+	return ""
+}
+
+// parseIntegrityRef reads the size and hashes from the download
+// response headers
+func parseIntegrityRef(headers proto.NetworkHeaders) *integrityRef {
+	ref := &integrityRef{size: -1}
+	// Content-Length is the compressed size if there is an encoding
+	encoded := headerValue(headers, "Content-Encoding") != ""
+	if n, err := strconv.ParseInt(headerValue(headers, "Content-Length"), 10, 64); err == nil && n >= 0 && !encoded {
+		ref.size = n
+	}
+	if sum, err := base64.StdEncoding.DecodeString(headerValue(headers, "Content-MD5")); err == nil && len(sum) == md5.Size {
+		ref.md5 = sum
+	}
+	// x-goog-hash may be repeated and is joined with newlines, eg
+	// crc32c=n03x6A==, md5=Ojk9c3dhfxgoKVVHYwFbHQ==
+	goog := strings.NewReplacer("\n", ",").Replace(headerValue(headers, "x-goog-hash"))
+	for _, field := range strings.Split(goog, ",") {
+		algo, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			continue
+		}
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		switch {
+		case algo == "md5" && len(sum) == md5.Size:
+			ref.md5 = sum
+		case algo == "crc32c" && len(sum) == 4:
+			ref.crc32c = sum
+		}
+	}
+	return ref
+}
+
+// verifyIntegrity checks the file at path against ref, returning an
+// errIncompleteDownload if it doesn't match.
+//
+// Checks without a reference value are skipped.
+func verifyIntegrity(slog *slog.Logger, path string, ref *integrityRef) error {
+	if ref == nil {
+		slog.Debug("Verify: no reference from the download response - skipping")
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	md5Hash := md5.New()
+	crcHash := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	size, err := io.Copy(io.MultiWriter(md5Hash, crcHash), f)
+	if err != nil {
+		return fmt.Errorf("failed to read download to verify it: %w", err)
+	}
+	slog.Debug("Verify: size", "expected", ref.size, "got", size)
+	if ref.size >= 0 && size != ref.size {
+		return fmt.Errorf("download is %d bytes but the server sent %d: %w", size, ref.size, errIncompleteDownload)
+	}
+	if ref.md5 != nil {
+		sum := md5Hash.Sum(nil)
+		slog.Debug("Verify: md5", "expected", fmt.Sprintf("%x", ref.md5), "got", fmt.Sprintf("%x", sum))
+		if !bytes.Equal(sum, ref.md5) {
+			return fmt.Errorf("download md5 %x doesn't match %x from the server: %w", sum, ref.md5, errIncompleteDownload)
+		}
+	}
+	if ref.crc32c != nil {
+		sum := binary.BigEndian.AppendUint32(nil, crcHash.Sum32())
+		slog.Debug("Verify: crc32c", "expected", fmt.Sprintf("%x", ref.crc32c), "got", fmt.Sprintf("%x", sum))
+		if !bytes.Equal(sum, ref.crc32c) {
+			return fmt.Errorf("download crc32c %x doesn't match %x from the server: %w", sum, ref.crc32c, errIncompleteDownload)
+		}
+	}
+	if ref.md5 == nil && ref.crc32c == nil {
+		slog.Debug("Verify: no hash from the server - checked size only")
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	webhookTimeout = 10 * time.Second // timeout for each webhook attempt
+	webhookTries   = 3                // number of attempts to deliver a webhook
+)
+
+// notifyWebhook sends rec as JSON to the -webhook-url in the
+// background.
+//
+// Failed deliveries are retried a few times then dropped.
+func notifyWebhook(rec downloadRecord) {
+	if *webhookURL == "" {
+		return
+	}
+	body, err := json.Marshal(rec)
+	if err != nil {
+		slog.Error("Failed to encode webhook", "err", err)
+		return
+	}
+	go func() {
+		slog := slog.With("id", rec.ID, "url", *webhookURL)
+		for try := 1; try <= webhookTries; try++ {
+			err := postWebhook(body)
+			if err == nil {
+				slog.Debug("Sent webhook", "status", rec.Status)
+				return
+			}
+			slog.Warn("Failed to send webhook", "try", try, "tries", webhookTries, "err", err)
+			time.Sleep(time.Duration(try) * time.Second)
+		}
+		slog.Error("Giving up sending webhook")
+	}()
+}
+
+// postWebhook makes one attempt to POST body to the -webhook-url
+func postWebhook(body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, *webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", program+"/"+version)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxZipItems is the maximum number of photo IDs in a POST /zip
+const maxZipItems = 1000
+
+// zipManifestName is the name of the manifest entry written last in
+// the ZIP
+const zipManifestName = "manifest.json"
+
+// zipRequest is the JSON body for POST /zip
+type zipRequest struct {
+	IDs    []string `json:"ids"`
+	Frames bool     `json:"frames"` // download every frame of bursts and stacks
+}
+
+// zipItem is the manifest entry for one photo in the ZIP
+type zipItem struct {
+	ID       string `json:"id"`
+	Frame    int    `json:"frame,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Code     string `json:"code,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// uniqueName returns name, or name with a number added before the
+// extension if it is already used, and marks it as used.
+func uniqueName(used map[string]bool, name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; used[name] || name == zipManifestName; i++ {
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	used[name] = true
+	return name
+}
+
+// zipPhoto downloads frame of photoID, 0 for the default, and copies
+// it into zw
+func (g *Gphotos) zipPhoto(zw *zip.Writer, used map[string]bool, photoID string, frame int) (item zipItem, err error) {
+	item.ID = photoID
+	item.Frame = frame
+	path, filename, err := g.downloadNamed(photoID, frame)
+	if err != nil {
+		return item, err
+	}
+	defer removeDownload(slog.With("id", photoID), photoID, path)
+	if filename == "" {
+		filename = photoID + filepath.Ext(path)
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return item, err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	item.Filename = uniqueName(used, filename)
+	// Photos and videos are already compressed so just store them
+	out, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     item.Filename,
+		Method:   zip.Store,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return item, err
+	}
+	item.Size, err = io.Copy(out, in)
+	return item, err
+}
+
+// Download a list of photo IDs streaming them back as a ZIP.
+//
+// The photos are downloaded one at a time and copied into the ZIP as
+// they arrive. The last entry is a manifest listing the file for each
+// photo ID or the error if it failed.
+func (g *Gphotos) postZip(w http.ResponseWriter, r *http.Request) {
+	var req zipRequest
+	err := json.NewDecoder(io.LimitReader(r.Body, 1024*1024)).Decode(&req)
+	if err != nil {
+		writeErrorCode(w, codeBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	slog.Info("got zip request", "items", len(req.IDs))
+	if len(req.IDs) == 0 {
+		writeErrorCode(w, codeBadRequest, "no photo IDs in ids")
+		return
+	}
+	if len(req.IDs) > maxZipItems {
+		writeErrorCode(w, codeBadRequest, fmt.Sprintf("too many photo IDs - maximum is %d", maxZipItems))
+		return
+	}
+	// Check all the IDs before starting so errors can be reported
+	// with the status code
+	for _, photoID := range req.IDs {
+		if !g.checkPhotoID(w, photoID) {
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="photos.zip"`)
+	zw := zip.NewWriter(w)
+	used := map[string]bool{}
+	var manifest []zipItem
+	for _, photoID := range req.IDs {
+		if r.Context().Err() != nil {
+			slog.Warn("Zip client went away - stopping", "err", r.Context().Err())
+			return
+		}
+		frames := []int{0}
+		if req.Frames {
+			n, err := g.StackFrames(photoID)
+			if err != nil {
+				slog.Error("Failed to read stack frames", "id", photoID, "err", err)
+			}
+			if n > 0 {
+				frames = frames[:0]
+				for frame := 1; frame <= n; frame++ {
+					frames = append(frames, frame)
+				}
+			}
+		}
+		for _, frame := range frames {
+			item, err := g.zipPhoto(zw, used, photoID, frame)
+			if err != nil {
+				slog.Error("Failed to add photo to zip", "id", photoID, "frame", frame, "err", err)
+				item.Status = errorStatus(err)
+				item.Code = errorCode(err)
+				item.Error = err.Error()
+			}
+			manifest = append(manifest, item)
+		}
+	}
+
+	out, err := zw.Create(zipManifestName)
+	if err == nil {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "\t")
+		err = enc.Encode(manifest)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		slog.Error("Failed to write zip", "err", err)
+	}
+}